@@ -0,0 +1,289 @@
+package borrower
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"os"
+	"strconv"
+	"text/tabwriter"
+	"time"
+
+	"github.com/xssnick/tonutils-go/address"
+	"github.com/xssnick/tonutils-go/tlb"
+)
+
+// LoanRequestRecord is a loan request sent for a round, with the parts of its value.
+type LoanRequestRecord struct {
+	QueryId    uint64    `json:"query_id"`
+	SentAt     int64     `json:"sent_at"`
+	Lt         uint64    `json:"lt"`
+	Hash       string    `json:"hash"`
+	Value      tlb.Coins `json:"value"`
+	Collateral tlb.Coins `json:"collateral"`
+	Fee        tlb.Coins `json:"fee"`
+	MinPayment tlb.Coins `json:"min_payment"`
+	Stake      tlb.Coins `json:"stake"`
+	Loan       tlb.Coins `json:"loan"`
+}
+
+// LoanReturn is TON that the treasury or the loan contract of a round sent back to the wallet.
+type LoanReturn struct {
+	At     int64     `json:"at"`
+	Lt     uint64    `json:"lt"`
+	Hash   string    `json:"hash"`
+	From   string    `json:"from"`
+	Op     uint32    `json:"op"`
+	Amount tlb.Coins `json:"amount"`
+}
+
+// RoundAccount records what a round cost and returned.
+type RoundAccount struct {
+	Round       uint32               `json:"round"`
+	LoanAddress string               `json:"loan_address"`
+	ReleaseAt   int64                `json:"release_at"`
+	Requests    []*LoanRequestRecord `json:"requests"`
+	Returns     []*LoanReturn        `json:"returns"`
+}
+
+// RoundPnl is the profit and loss of a round. Shortfall is the part of the collateral and stake that didn't come
+// back, which is only a heuristic for a punishment, since a reward larger than a punishment hides it, and other losses
+// show up in it too. Apr is the net profit over the sent value, per year of the time between the first request and
+// the last return.
+type RoundPnl struct {
+	Round      uint32   `json:"round"`
+	Settled    bool     `json:"settled"`
+	Requests   int      `json:"requests"`
+	Sent       string   `json:"sent"`
+	Collateral string   `json:"collateral"`
+	Fee        string   `json:"fee"`
+	MinPayment string   `json:"min_payment"`
+	Stake      string   `json:"stake"`
+	Loan       string   `json:"loan"`
+	Returned   string   `json:"returned"`
+	Shortfall  string   `json:"shortfall"`
+	Net        string   `json:"net"`
+	Apr        *float64 `json:"apr"`
+}
+
+func (s *Store) account(roundSince uint32) *RoundAccount {
+	for _, a := range s.Accounts {
+		if a.Round == roundSince {
+			return a
+		}
+	}
+	return nil
+}
+
+// recordLoanRequest records a sent loan request in the account of its round.
+func (s *Store) recordLoanRequest(roundSince uint32, loanAddress *address.Address, releaseAt int64,
+	r *LoanRequestRecord) {
+	a := s.account(roundSince)
+	if a == nil {
+		a = &RoundAccount{Round: roundSince}
+		s.Accounts = append(s.Accounts, a)
+	}
+	a.LoanAddress = loanAddress.StringRaw()
+	a.ReleaseAt = releaseAt
+	a.Requests = append(a.Requests, r)
+	s.save()
+}
+
+// attribute finds the round of TON sent to the wallet. A message from the loan contract of a round, or a message of
+// the treasury with the query id of a request or the round in its body belongs to that round. Other messages of the
+// treasury belong to the latest round released before them, since the treasury pays the validator when it finishes
+// the round.
+func (s *Store) attribute(from *address.Address, treasuryAddress *address.Address, queryId uint64, round uint32,
+	now int64) *RoundAccount {
+	for _, a := range s.Accounts {
+		if a.LoanAddress == from.StringRaw() {
+			return a
+		}
+	}
+	if from.StringRaw() != treasuryAddress.StringRaw() {
+		return nil
+	}
+	for _, a := range s.Accounts {
+		for _, r := range a.Requests {
+			if r.QueryId == queryId {
+				return a
+			}
+		}
+	}
+	if a := s.account(round); a != nil {
+		return a
+	}
+	var latest *RoundAccount
+	for _, a := range s.Accounts {
+		if a.ReleaseAt <= now && len(a.Requests) > 0 && a.Requests[0].SentAt <= now &&
+			(latest == nil || a.Round > latest.Round) {
+			latest = a
+		}
+	}
+	return latest
+}
+
+func (a *RoundAccount) pnl(now int64) *RoundPnl {
+	sent, collateral, fee, minPayment, stake := big.NewInt(0), big.NewInt(0), big.NewInt(0), big.NewInt(0),
+		big.NewInt(0)
+	loan := big.NewInt(0)
+	for _, r := range a.Requests {
+		sent.Add(sent, r.Value.Nano())
+		collateral.Add(collateral, r.Collateral.Nano())
+		fee.Add(fee, r.Fee.Nano())
+		minPayment.Add(minPayment, r.MinPayment.Nano())
+		stake.Add(stake, r.Stake.Nano())
+		loan = r.Loan.Nano()
+	}
+	returned := big.NewInt(0)
+	lastReturn := int64(0)
+	for _, r := range a.Returns {
+		returned.Add(returned, r.Amount.Nano())
+		if r.At > lastReturn {
+			lastReturn = r.At
+		}
+	}
+
+	p := &RoundPnl{
+		Round:      a.Round,
+		Settled:    len(a.Returns) > 0 && now > a.ReleaseAt,
+		Requests:   len(a.Requests),
+		Sent:       tlb.FromNanoTON(sent).String(),
+		Collateral: tlb.FromNanoTON(collateral).String(),
+		Fee:        tlb.FromNanoTON(fee).String(),
+		MinPayment: tlb.FromNanoTON(minPayment).String(),
+		Stake:      tlb.FromNanoTON(stake).String(),
+		Loan:       tlb.FromNanoTON(loan).String(),
+		Returned:   tlb.FromNanoTON(returned).String(),
+		Shortfall:  "0",
+		Net:        "0",
+	}
+	if !p.Settled {
+		return p
+	}
+
+	net := new(big.Int).Sub(returned, sent)
+	p.Net = formatSignedTon(net)
+	shortfall := new(big.Int).Add(collateral, stake)
+	shortfall.Sub(shortfall, returned)
+	if shortfall.Sign() > 0 {
+		p.Shortfall = tlb.FromNanoTON(shortfall).String()
+	}
+	duration := lastReturn - a.Requests[0].SentAt
+	if duration > 0 && sent.Sign() > 0 {
+		ratio, _ := new(big.Rat).SetFrac(net, sent).Float64()
+		apr := ratio * (365 * 24 * time.Hour).Seconds() / float64(duration) * 100
+		p.Apr = &apr
+	}
+	return p
+}
+
+// ReportPnl prints the profit and loss of the rounds since from and before to, as a table, CSV, or JSON.
+func ReportPnl(from string, to string, format string) error {
+	return runCommand(func() {
+		config := loadConfig()
+
+		fromTime, toTime := parseReportRange(from, to)
+
+		api, ctx := loadApi(config)
+
+		store := loadStore(config)
+
+		treasuryAddress := address.MustParseAddr(config.Treasury)
+
+		mainchainInfo := loadMainchainInfo(api, ctx)
+
+		validatorsElectedFor, _, _, _, stakeHeldFor := loadBlockchainConfig(api, ctx, mainchainInfo)
+
+		indexWallet(config, api, ctx, mainchainInfo, store, loadWalletAddress(config.Wallet), treasuryAddress,
+			validatorsElectedFor+stakeHeldFor)
+
+		now := time.Now().Unix()
+		rows := []*RoundPnl{}
+		for _, a := range store.Accounts {
+			since := time.Unix(int64(a.Round), 0)
+			if since.Before(fromTime) || !since.Before(toTime) {
+				continue
+			}
+			rows = append(rows, a.pnl(now))
+		}
+
+		switch format {
+		case "json":
+			printJson(rows)
+		case "csv":
+			w := csv.NewWriter(os.Stdout)
+			w.Write([]string{"round", "settled", "requests", "sent", "collateral", "fee", "min_payment", "stake", "loan",
+				"returned", "shortfall", "net", "apr"})
+			for _, p := range rows {
+				w.Write([]string{strconv.FormatUint(uint64(p.Round), 10), strconv.FormatBool(p.Settled),
+					strconv.Itoa(p.Requests), p.Sent, p.Collateral, p.Fee, p.MinPayment, p.Stake, p.Loan, p.Returned,
+					p.Shortfall, p.Net, formatApr(p.Apr)})
+			}
+			w.Flush()
+		default:
+			tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+			fmt.Fprintln(tw, "ROUND\tSETTLED\tREQUESTS\tSENT\tCOLLATERAL\tFEE\tMIN PAYMENT\tSTAKE\tLOAN\tRETURNED\t"+
+				"SHORTFALL\tNET\tAPR")
+			for _, p := range rows {
+				fmt.Fprintf(tw, "%v\t%v\t%v\t%v\t%v\t%v\t%v\t%v\t%v\t%v\t%v\t%v\t%v\n",
+					time.Unix(int64(p.Round), 0).Format(TimeFormat), p.Settled, p.Requests, p.Sent, p.Collateral, p.Fee,
+					p.MinPayment, p.Stake, p.Loan, p.Returned, p.Shortfall, p.Net, formatApr(p.Apr))
+			}
+			tw.Flush()
+		}
+	})
+}
+
+// formatSignedTon formats a TON amount that may be negative, which tlb.Coins doesn't format correctly.
+func formatSignedTon(n *big.Int) string {
+	if n.Sign() < 0 {
+		return "-" + tlb.FromNanoTON(new(big.Int).Neg(n)).String()
+	}
+	return tlb.FromNanoTON(n).String()
+}
+
+func formatApr(apr *float64) string {
+	if apr == nil {
+		return ""
+	}
+	return strconv.FormatFloat(*apr, 'f', 2, 64)
+}
+
+func printJson(v any) {
+	contents, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		panic(fmt.Sprintf("Error in encoding JSON: %v", err))
+	}
+	fmt.Println(string(contents))
+}
+
+// parseReportRange parses the dates of a report, where to is inclusive. The range is unbounded without dates.
+func parseReportRange(from string, to string) (time.Time, time.Time) {
+	fromTime := time.Unix(0, 0)
+	toTime := time.Unix(1<<32, 0)
+	var err error
+	if from != "" {
+		fromTime, err = time.ParseInLocation(time.DateOnly, from, time.Local)
+		if err != nil {
+			panic(fmt.Sprintf("Error, invalid --from date, expected YYYY-MM-DD but got: %v", from))
+		}
+	}
+	if to != "" {
+		toTime, err = time.ParseInLocation(time.DateOnly, to, time.Local)
+		if err != nil {
+			panic(fmt.Sprintf("Error, invalid --to date, expected YYYY-MM-DD but got: %v", to))
+		}
+		toTime = toTime.AddDate(0, 0, 1)
+	}
+	return fromTime, toTime
+}
+
+// loadWalletAddress returns the configured address of the wallet, or derives it from the wallet when it's not set.
+func loadWalletAddress(config Wallet) *address.Address {
+	if config.Address != "" {
+		return parseAnyAddress(config.Address)
+	}
+	return loadWallet(config, nil).Address()
+}
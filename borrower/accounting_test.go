@@ -0,0 +1,182 @@
+package borrower
+
+import (
+	"math"
+	"testing"
+
+	"github.com/xssnick/tonutils-go/address"
+	"github.com/xssnick/tonutils-go/tlb"
+)
+
+const testYear = 365 * 24 * 60 * 60
+
+func testAccount(round uint32, sentAt int64, releaseAt int64, returns ...*LoanReturn) *RoundAccount {
+	return &RoundAccount{
+		Round:       round,
+		LoanAddress: address.NewAddress(0, 0, append(make([]byte, 31), byte(round))).StringRaw(),
+		ReleaseAt:   releaseAt,
+		Requests: []*LoanRequestRecord{{
+			QueryId:    uint64(round) * 10,
+			SentAt:     sentAt,
+			Value:      tlb.MustFromTON("100"),
+			Collateral: tlb.MustFromTON("10"),
+			Fee:        tlb.MustFromTON("1"),
+			MinPayment: tlb.MustFromTON("5"),
+			Stake:      tlb.MustFromTON("84"),
+			Loan:       tlb.MustFromTON("1000"),
+		}},
+		Returns: returns,
+	}
+}
+
+func TestPnl(t *testing.T) {
+	tests := []struct {
+		name      string
+		account   *RoundAccount
+		now       int64
+		settled   bool
+		net       string
+		shortfall string
+		apr       *float64
+	}{
+		{
+			name:    "no returns",
+			account: testAccount(1, 0, 100),
+			now:     200,
+			net:     "0", shortfall: "0",
+		},
+		{
+			name:    "not released",
+			account: testAccount(1, 0, 100, &LoanReturn{At: 50, Amount: tlb.MustFromTON("110")}),
+			now:     100,
+			net:     "0", shortfall: "0",
+		},
+		{
+			name:    "profit",
+			account: testAccount(1, 0, 100, &LoanReturn{At: testYear / 10, Amount: tlb.MustFromTON("110")}),
+			now:     testYear,
+			settled: true, net: "10", shortfall: "0", apr: ptr(100.0),
+		},
+		{
+			name: "shortfall",
+			account: testAccount(1, 0, 100,
+				&LoanReturn{At: testYear / 2, Amount: tlb.MustFromTON("20")},
+				&LoanReturn{At: testYear, Amount: tlb.MustFromTON("30")}),
+			now:     testYear,
+			settled: true, net: "-50", shortfall: "44", apr: ptr(-50.0),
+		},
+		{
+			name:    "return with the request",
+			account: testAccount(1, 100, 100, &LoanReturn{At: 100, Amount: tlb.MustFromTON("100")}),
+			now:     200,
+			settled: true, net: "0", shortfall: "0",
+		},
+		{
+			name:    "return before the request",
+			account: testAccount(1, 100, 100, &LoanReturn{At: 50, Amount: tlb.MustFromTON("90")}),
+			now:     200,
+			settled: true, net: "-10", shortfall: "4",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			p := test.account.pnl(test.now)
+			if p.Settled != test.settled || p.Net != test.net || p.Shortfall != test.shortfall {
+				t.Fatalf("expected settled %v, net %v and shortfall %v, got %v, %v and %v", test.settled, test.net,
+					test.shortfall, p.Settled, p.Net, p.Shortfall)
+			}
+			if p.Sent != "100" || p.Loan != "1000" {
+				t.Fatalf("expected 100 TON sent for a loan of 1000 TON, got %v and %v", p.Sent, p.Loan)
+			}
+			if (p.Apr == nil) != (test.apr == nil) {
+				t.Fatalf("expected apr %v, got %v", formatApr(test.apr), formatApr(p.Apr))
+			}
+			if p.Apr != nil && math.Abs(*p.Apr-*test.apr) > 1e-9 {
+				t.Fatalf("expected apr %v, got %v", *test.apr, *p.Apr)
+			}
+		})
+	}
+}
+
+func TestAttribute(t *testing.T) {
+	treasury := address.NewAddress(0, 0, make([]byte, 32))
+	stranger := address.NewAddress(0, 0, append(make([]byte, 31), 0xff))
+	first := testAccount(1, 100, 1000)
+	second := testAccount(2, 1100, 2000)
+	third := testAccount(3, 2100, 3000)
+	store := &Store{Accounts: []*RoundAccount{first, second, third}}
+
+	tests := []struct {
+		name    string
+		from    *address.Address
+		queryId uint64
+		round   uint32
+		now     int64
+		want    *RoundAccount
+	}{
+		{
+			name: "loan contract",
+			from: address.MustParseRawAddr(second.LoanAddress),
+			now:  500,
+			want: second,
+		},
+		{
+			name: "stranger",
+			from: stranger,
+			now:  5000,
+		},
+		{
+			name:    "query id",
+			from:    treasury,
+			queryId: 10,
+			now:     5000,
+			want:    first,
+		},
+		{
+			name:  "round",
+			from:  treasury,
+			round: 2,
+			now:   5000,
+			want:  second,
+		},
+		{
+			name:  "latest released round",
+			from:  treasury,
+			round: 7,
+			now:   2500,
+			want:  second,
+		},
+		{
+			name: "all rounds released",
+			from: treasury,
+			now:  5000,
+			want: third,
+		},
+		{
+			name: "no round released",
+			from: treasury,
+			now:  900,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			a := store.attribute(test.from, treasury, test.queryId, test.round, test.now)
+			if a != test.want {
+				t.Fatalf("expected %v, got %v", roundOf(test.want), roundOf(a))
+			}
+		})
+	}
+}
+
+func roundOf(a *RoundAccount) any {
+	if a == nil {
+		return nil
+	}
+	return a.Round
+}
+
+func ptr[T any](v T) *T {
+	return &v
+}
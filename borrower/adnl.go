@@ -0,0 +1,69 @@
+package borrower
+
+import (
+	"encoding/hex"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// resolveAdnlAddress returns the hex encoded ADNL address to validate with. A configured address must be one of the
+// ADNL addresses of the engine. Without one, the address used by the latest validator key of the engine is used, or
+// the only ADNL address of the engine which is not used for DHT or the full node.
+func resolveAdnlAddress(engineConfig *EngineConfig, configured string) string {
+	known := map[string]bool{}
+	for _, a := range engineConfig.Adnl {
+		known[a.Id.Hex()] = true
+	}
+
+	if configured != "" {
+		adnlAddress := strings.ToLower(strings.TrimSpace(configured))
+		bytes, err := hex.DecodeString(adnlAddress)
+		if err != nil || len(bytes) != 32 {
+			panic(fmt.Sprintf("Error, adnl_address must be 64 hex characters but got: %v", configured))
+		}
+		if !known[adnlAddress] {
+			panic(fmt.Sprintf("Error, adnl_address %v is not known to the validator engine, its ADNL addresses are: %v",
+				adnlAddress, strings.Join(sortedKeys(known), ", ")))
+		}
+		return adnlAddress
+	}
+
+	var latest *ValidatorConfig
+	for i := range engineConfig.Validators {
+		v := &engineConfig.Validators[i]
+		if len(v.AdnlAddrs) > 0 && (latest == nil || v.ElectionDate > latest.ElectionDate) {
+			latest = v
+		}
+	}
+	if latest != nil {
+		adnlAddress := latest.AdnlAddrs[len(latest.AdnlAddrs)-1].Id.Hex()
+		if known[adnlAddress] {
+			return adnlAddress
+		}
+	}
+
+	candidates := map[string]bool{}
+	for id := range known {
+		candidates[id] = true
+	}
+	for _, d := range engineConfig.Dht {
+		delete(candidates, d.Id.Hex())
+	}
+	delete(candidates, engineConfig.FullNode.Hex())
+	if len(candidates) == 1 {
+		return sortedKeys(candidates)[0]
+	}
+
+	panic(fmt.Sprintf("Error, can't find the ADNL address for validation, set adnl_address to one of: %v",
+		strings.Join(sortedKeys(candidates), ", ")))
+}
+
+func sortedKeys(m map[string]bool) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
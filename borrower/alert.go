@@ -0,0 +1,109 @@
+package borrower
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+)
+
+type AlertLevel string
+
+const (
+	AlertWarning  AlertLevel = "warning"
+	AlertCritical AlertLevel = "critical"
+)
+
+const (
+	// alertRepeat is the minimum time before raising the same alert again.
+	alertRepeat  = 1 * time.Hour
+	alertHistory = 7 * 24 * time.Hour
+)
+
+type alerter struct {
+	config Alerts
+	store  *Store
+}
+
+func newAlerter(config Alerts, store *Store) *alerter {
+	return &alerter{
+		config: config,
+		store:  store,
+	}
+}
+
+// raise logs the alert and posts it to the webhook, unless an alert with the same key was raised recently.
+func (a *alerter) raise(key string, level AlertLevel, format string, args ...any) {
+	message := fmt.Sprintf(format, args...)
+	now := time.Now()
+	if a.store.Alerts == nil {
+		a.store.Alerts = map[string]int64{}
+	}
+	if last, found := a.store.Alerts[key]; found && now.Sub(time.Unix(last, 0)) < alertRepeat {
+		return
+	}
+	a.store.Alerts[key] = now.Unix()
+	a.store.save()
+
+	if level == AlertCritical {
+		log.Printf("🚨 %s", message)
+	} else {
+		log.Printf("⚠️  %s", message)
+	}
+
+	if a.config.Webhook == "" {
+		return
+	}
+	err := postAlert(a.config.Webhook, level, message, now)
+	if err != nil {
+		log.Printf("⚠️  Failed to post alert to webhook: %v", err)
+	}
+}
+
+// prune forgets alerts that were raised a long time ago.
+func (a *alerter) prune() {
+	cutoff := time.Now().Add(-alertHistory).Unix()
+	changed := false
+	for key, at := range a.store.Alerts {
+		if at < cutoff {
+			delete(a.store.Alerts, key)
+			changed = true
+		}
+	}
+	if changed {
+		a.store.save()
+	}
+}
+
+func postAlert(webhook string, level AlertLevel, message string, at time.Time) error {
+	body, err := json.Marshal(map[string]any{
+		"level":   level,
+		"message": message,
+		"time":    at.Unix(),
+	})
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	request, err := http.NewRequestWithContext(ctx, http.MethodPost, webhook, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	request.Header.Set("Content-Type", "application/json")
+
+	response, err := http.DefaultClient.Do(request)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+	if response.StatusCode/100 != 2 {
+		return fmt.Errorf("unexpected status %v", response.Status)
+	}
+	return nil
+}
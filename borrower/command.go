@@ -0,0 +1,14 @@
+package borrower
+
+import "fmt"
+
+// runCommand converts the panics used for error handling into an error for command line tools.
+func runCommand(f func()) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("%v", r)
+		}
+	}()
+	f()
+	return
+}
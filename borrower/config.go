@@ -3,7 +3,9 @@ package borrower
 import (
 	"math/big"
 	"os"
+	"time"
 
+	"github.com/xssnick/tonutils-go/address"
 	"github.com/xssnick/tonutils-go/tvm/cell"
 	"gopkg.in/yaml.v3"
 )
@@ -13,11 +15,23 @@ var ConfigFile = "borrower.yaml"
 type Config struct {
 	Treasury        string
 	GlobalConfig    string `yaml:"global_config"`
+	DataDir         string `yaml:"data_dir"`
+	Monitor         bool
+	Metrics         string
+	Alerts          Alerts
 	Borrow          Borrow
 	Wallet          Wallet
+	Sweep           Sweep
+	Forecast        Forecast
 	ValidatorEngine ValidatorEngine `yaml:"validator_engine"`
 }
 
+type Alerts struct {
+	Webhook         string
+	RecoveryTimeout time.Duration            `yaml:"recovery_timeout"`
+	StateTimeouts   map[string]time.Duration `yaml:"state_timeouts"`
+}
+
 type Borrow struct {
 	Active               bool
 	Stake                string
@@ -25,23 +39,46 @@ type Borrow struct {
 	MinPayment           string  `yaml:"min_payment"`
 	MaxFactorRatio       float32 `yaml:"max_factor_ratio"`
 	ValidatorRewardShare uint8   `yaml:"validator_reward_share"`
+	MaxRequestLoanFee    string  `yaml:"max_request_loan_fee"`
 }
 
 type Wallet struct {
-	Type    string
-	Path    string
-	Version string
+	Type            string
+	Path            string
+	Encrypted       bool
+	Address         string
+	Version         string
+	SubwalletId     *uint32       `yaml:"subwallet_id"`
+	NetworkGlobalId int32         `yaml:"network_global_id"`
+	MessageTtl      time.Duration `yaml:"message_ttl"`
+	PublicKey       string        `yaml:"public_key"`
+	Signer          string
+	SignerToken     string `yaml:"signer_token"`
+}
+
+type Sweep struct {
+	Address   string
+	Buffer    string
+	MinAmount string `yaml:"min_amount"`
+}
+
+type Forecast struct {
+	Rounds     int
+	WarnBefore time.Duration `yaml:"warn_before"`
 }
 
 type ValidatorEngine struct {
-	Executable     string
-	ClientKey      string `yaml:"client_key"`
-	ServerKey      string `yaml:"server_key"`
-	LiteserverKey  string `yaml:"liteserver_key"`
-	Ip             string `yaml:"ip"`
-	ControlPort    uint16 `yaml:"control_port"`
-	LiteserverPort uint16 `yaml:"liteserver_port"`
-	AdnlAddress    string `yaml:"adnl_address"`
+	Protocol         string
+	Executable       string
+	ClientKey        string        `yaml:"client_key"`
+	ServerKey        string        `yaml:"server_key"`
+	LiteserverKey    string        `yaml:"liteserver_key"`
+	Ip               string        `yaml:"ip"`
+	ControlPort      uint16        `yaml:"control_port"`
+	LiteserverPort   uint16        `yaml:"liteserver_port"`
+	AdnlAddress      string        `yaml:"adnl_address"`
+	KeyCleanup       bool          `yaml:"key_cleanup"`
+	KeyCleanupMargin time.Duration `yaml:"key_cleanup_margin"`
 }
 
 func ReadConfig() (config *Config, err error) {
@@ -54,10 +91,25 @@ func ReadConfig() (config *Config, err error) {
 	return
 }
 
+var ConfigElector int32 = 1
+var ConfigGlobalId int32 = 19
 var ConfigElection int32 = 15
+var ConfigValidators int32 = 16
 var ConfigStake int32 = 17
 var ConfigCurrentValidators int32 = 34
 
+func GetElectorAddress(c *cell.Cell) *address.Address {
+	// _ elector_addr:bits256 = ConfigParam 1;
+	s := c.BeginParse()
+	return address.NewAddress(0, 255, s.MustLoadSlice(256))
+}
+
+func GetGlobalId(c *cell.Cell) int32 {
+	// _ global_id:int32 = ConfigParam 19;
+	s := c.BeginParse()
+	return int32(s.MustLoadInt(32))
+}
+
 func GetElectionConfig(c *cell.Cell) (uint32, uint32, uint32, uint32) {
 	// _ validators_elected_for:uint32 elections_start_before:uint32
 	//   elections_end_before:uint32 stake_held_for:uint32
@@ -67,10 +119,38 @@ func GetElectionConfig(c *cell.Cell) (uint32, uint32, uint32, uint32) {
 		uint32(s.MustLoadUInt(32)), uint32(s.MustLoadUInt(32))
 }
 
-func GetMinStake(c *cell.Cell) *big.Int {
+func GetValidatorCounts(c *cell.Cell) (maxValidators uint16, maxMainValidators uint16, minValidators uint16) {
+	// _ max_validators:(## 16) max_main_validators:(## 16) min_validators:(## 16)
+	//   { max_validators >= max_main_validators }
+	//   { max_main_validators >= min_validators }
+	//   { min_validators >= 1 }
+	//   = ConfigParam 16;
+	s := c.BeginParse()
+	return uint16(s.MustLoadUInt(16)), uint16(s.MustLoadUInt(16)), uint16(s.MustLoadUInt(16))
+}
+
+// StakeConfig is the limits of the stakes in elections. MaxStakeFactor is fixed-point with 16 fractional bits, like
+// max_factor of a stake.
+type StakeConfig struct {
+	MinStake       *big.Int
+	MaxStake       *big.Int
+	MinTotalStake  *big.Int
+	MaxStakeFactor uint32
+}
+
+func GetStakeConfig(c *cell.Cell) *StakeConfig {
 	// _ min_stake:Grams max_stake:Grams min_total_stake:Grams max_stake_factor:uint32 = ConfigParam 17;
 	s := c.BeginParse()
-	return s.MustLoadBigCoins()
+	return &StakeConfig{
+		MinStake:       s.MustLoadBigCoins(),
+		MaxStake:       s.MustLoadBigCoins(),
+		MinTotalStake:  s.MustLoadBigCoins(),
+		MaxStakeFactor: uint32(s.MustLoadUInt(32)),
+	}
+}
+
+func GetMinStake(c *cell.Cell) *big.Int {
+	return GetStakeConfig(c).MinStake
 }
 
 func GetVsetTimes(c *cell.Cell) (since uint32, until uint32) {
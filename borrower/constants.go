@@ -4,6 +4,7 @@ const (
 	ParticipateInElection = 0x574a297b
 	VsetChanged           = 0x2f0b5b3b
 	FinishParticipation   = 0x23274435
+	LoanRequest           = 0x36335da9
 )
 
 const TimeFormat = "Jan 2 15:04 -0700"
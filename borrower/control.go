@@ -0,0 +1,302 @@
+package borrower
+
+import (
+	"context"
+	"crypto/ed25519"
+	"encoding/base64"
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/xssnick/tonutils-go/adnl"
+	"github.com/xssnick/tonutils-go/liteclient"
+	"github.com/xssnick/tonutils-go/tl"
+)
+
+func init() {
+	tl.Register(ControlQuery{}, "engine.validator.controlQuery data:bytes = Object")
+	tl.Register(ControlQueryError{}, "engine.validator.controlQueryError code:int message:string = engine.validator.ControlQueryError")
+
+	tl.Register(ControlGetStats{}, "engine.validator.getStats = engine.validator.Stats")
+	tl.Register(ControlGetConfig{}, "engine.validator.getConfig = engine.validator.JsonConfig")
+	tl.Register(ControlGenerateKeyPair{}, "engine.validator.generateKeyPair = engine.validator.KeyHash")
+	tl.Register(ControlExportPublicKey{}, "engine.validator.exportPublicKey key_hash:int256 = PublicKey")
+	tl.Register(ControlSign{}, "engine.validator.sign key_hash:int256 data:bytes = engine.validator.Signature")
+	tl.Register(ControlAddValidatorPermanentKey{}, "engine.validator.addValidatorPermanentKey key_hash:int256 election_date:int ttl:int = engine.validator.Success")
+	tl.Register(ControlAddValidatorTempKey{}, "engine.validator.addValidatorTempKey permanent_key_hash:int256 key_hash:int256 ttl:int = engine.validator.Success")
+	tl.Register(ControlAddValidatorAdnlAddress{}, "engine.validator.addValidatorAdnlAddress permanent_key_hash:int256 key_hash:int256 ttl:int = engine.validator.Success")
+	tl.Register(ControlDelValidatorPermanentKey{}, "engine.validator.delValidatorPermanentKey key_hash:int256 = engine.validator.Success")
+	tl.Register(ControlDelValidatorTempKey{}, "engine.validator.delValidatorTempKey permanent_key_hash:int256 key_hash:int256 = engine.validator.Success")
+	tl.Register(ControlDelValidatorAdnlAddress{}, "engine.validator.delValidatorAdnlAddress permanent_key_hash:int256 key_hash:int256 = engine.validator.Success")
+
+	tl.Register(ControlOneStat{}, "engine.validator.oneStat key:string value:string = engine.validator.OneStat")
+	tl.Register(ControlStats{}, "engine.validator.stats stats:(vector engine.validator.oneStat) = engine.validator.Stats")
+	tl.Register(ControlJsonConfig{}, "engine.validator.jsonConfig data:string = engine.validator.JsonConfig")
+	tl.Register(ControlKeyHash{}, "engine.validator.keyHash key_hash:int256 = engine.validator.KeyHash")
+	tl.Register(ControlSignature{}, "engine.validator.signature signature:bytes = engine.validator.Signature")
+	tl.Register(ControlSuccess{}, "engine.validator.success = engine.validator.Success")
+}
+
+type ControlQuery struct {
+	Data any `tl:"bytes struct boxed"`
+}
+
+type ControlQueryError struct {
+	Code    int32  `tl:"int"`
+	Message string `tl:"string"`
+}
+
+type ControlGetStats struct{}
+
+type ControlGetConfig struct{}
+
+type ControlGenerateKeyPair struct{}
+
+type ControlExportPublicKey struct {
+	KeyHash []byte `tl:"int256"`
+}
+
+type ControlSign struct {
+	KeyHash []byte `tl:"int256"`
+	Data    []byte `tl:"bytes"`
+}
+
+type ControlAddValidatorPermanentKey struct {
+	KeyHash      []byte `tl:"int256"`
+	ElectionDate int32  `tl:"int"`
+	Ttl          int32  `tl:"int"`
+}
+
+type ControlAddValidatorTempKey struct {
+	PermanentKeyHash []byte `tl:"int256"`
+	KeyHash          []byte `tl:"int256"`
+	Ttl              int32  `tl:"int"`
+}
+
+type ControlAddValidatorAdnlAddress struct {
+	PermanentKeyHash []byte `tl:"int256"`
+	KeyHash          []byte `tl:"int256"`
+	Ttl              int32  `tl:"int"`
+}
+
+type ControlDelValidatorPermanentKey struct {
+	KeyHash []byte `tl:"int256"`
+}
+
+type ControlDelValidatorTempKey struct {
+	PermanentKeyHash []byte `tl:"int256"`
+	KeyHash          []byte `tl:"int256"`
+}
+
+type ControlDelValidatorAdnlAddress struct {
+	PermanentKeyHash []byte `tl:"int256"`
+	KeyHash          []byte `tl:"int256"`
+}
+
+type ControlOneStat struct {
+	Key   string `tl:"string"`
+	Value string `tl:"string"`
+}
+
+type ControlStats struct {
+	Stats []ControlOneStat `tl:"vector struct"`
+}
+
+type ControlJsonConfig struct {
+	Data string `tl:"string"`
+}
+
+type ControlKeyHash struct {
+	KeyHash []byte `tl:"int256"`
+}
+
+type ControlSignature struct {
+	Signature []byte `tl:"bytes"`
+}
+
+type ControlSuccess struct{}
+
+// EngineStats is the typed result of getStats.
+type EngineStats struct {
+	UnixTime             int64
+	MasterchainBlockTime int64
+	All                  map[string]string
+}
+
+// ControlClient speaks the control interface of the validator engine directly over ADNL TCP, authenticating with
+// the same client key as validator-engine-console.
+type ControlClient struct {
+	pool *liteclient.ConnectionPool
+}
+
+func NewControlClient(config ValidatorEngine) (*ControlClient, error) {
+	clientKey, err := readKeyFile(config.ClientKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read client key: %w", err)
+	}
+	serverKey, err := readKeyFile(config.ServerKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read server key: %w", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	pool := liteclient.NewConnectionPool()
+	address := fmt.Sprintf("%v:%v", config.Ip, config.ControlPort)
+	err = pool.AddConnection(ctx, address, base64.StdEncoding.EncodeToString(serverKey),
+		ed25519.NewKeyFromSeed(clientKey))
+	if err != nil {
+		pool.Stop()
+		return nil, fmt.Errorf("failed to connect to control interface at %v: %w", address, err)
+	}
+
+	return &ControlClient{pool: pool}, nil
+}
+
+// readKeyFile reads a key file of the validator engine, which is a TL serialized pk.ed25519 or pub.ed25519.
+func readKeyFile(path string) ([]byte, error) {
+	contents, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if len(contents) < 32 {
+		return nil, fmt.Errorf("invalid key file %v", path)
+	}
+	return contents[len(contents)-32:], nil
+}
+
+func (c *ControlClient) Close() {
+	c.pool.Stop()
+}
+
+func (c *ControlClient) query(request tl.Serializable) (tl.Serializable, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	var response tl.Serializable
+	err := c.pool.QueryADNL(ctx, ControlQuery{Data: request}, &response)
+	if err != nil {
+		return nil, err
+	}
+	if e, ok := response.(ControlQueryError); ok {
+		return nil, fmt.Errorf("control query error %v: %v", e.Code, e.Message)
+	}
+	return response, nil
+}
+
+func (c *ControlClient) GetStats() (*EngineStats, error) {
+	response, err := c.query(ControlGetStats{})
+	if err != nil {
+		return nil, err
+	}
+	stats, ok := response.(ControlStats)
+	if !ok {
+		return nil, fmt.Errorf("unexpected response to getStats: %T", response)
+	}
+	result := &EngineStats{All: map[string]string{}}
+	for _, s := range stats.Stats {
+		result.All[s.Key] = s.Value
+	}
+	result.UnixTime, err = strconv.ParseInt(result.All["unixtime"], 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("invalid unixtime in stats: %w", err)
+	}
+	result.MasterchainBlockTime, err = strconv.ParseInt(result.All["masterchainblocktime"], 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("invalid masterchainblocktime in stats: %w", err)
+	}
+	return result, nil
+}
+
+// GetConfig returns the config of the engine as raw JSON.
+func (c *ControlClient) GetConfig() (string, error) {
+	response, err := c.query(ControlGetConfig{})
+	if err != nil {
+		return "", err
+	}
+	config, ok := response.(ControlJsonConfig)
+	if !ok {
+		return "", fmt.Errorf("unexpected response to getConfig: %T", response)
+	}
+	return config.Data, nil
+}
+
+func (c *ControlClient) GenerateKeyPair() ([]byte, error) {
+	response, err := c.query(ControlGenerateKeyPair{})
+	if err != nil {
+		return nil, err
+	}
+	keyHash, ok := response.(ControlKeyHash)
+	if !ok {
+		return nil, fmt.Errorf("unexpected response to generateKeyPair: %T", response)
+	}
+	return keyHash.KeyHash, nil
+}
+
+func (c *ControlClient) ExportPublicKey(keyHash []byte) (ed25519.PublicKey, error) {
+	response, err := c.query(ControlExportPublicKey{KeyHash: keyHash})
+	if err != nil {
+		return nil, err
+	}
+	switch publicKey := response.(type) {
+	case adnl.PublicKeyED25519:
+		return publicKey.Key, nil
+	case *adnl.PublicKeyED25519:
+		return publicKey.Key, nil
+	}
+	return nil, fmt.Errorf("unexpected response to exportPublicKey: %T", response)
+}
+
+func (c *ControlClient) Sign(keyHash []byte, data []byte) ([]byte, error) {
+	response, err := c.query(ControlSign{KeyHash: keyHash, Data: data})
+	if err != nil {
+		return nil, err
+	}
+	signature, ok := response.(ControlSignature)
+	if !ok {
+		return nil, fmt.Errorf("unexpected response to sign: %T", response)
+	}
+	return signature.Signature, nil
+}
+
+func (c *ControlClient) AddValidatorPermanentKey(keyHash []byte, electionDate uint32, ttl uint32) error {
+	return c.expectSuccess("addValidatorPermanentKey",
+		ControlAddValidatorPermanentKey{KeyHash: keyHash, ElectionDate: int32(electionDate), Ttl: int32(ttl)})
+}
+
+func (c *ControlClient) AddValidatorTempKey(permanentKeyHash []byte, keyHash []byte, ttl uint32) error {
+	return c.expectSuccess("addValidatorTempKey",
+		ControlAddValidatorTempKey{PermanentKeyHash: permanentKeyHash, KeyHash: keyHash, Ttl: int32(ttl)})
+}
+
+func (c *ControlClient) AddValidatorAdnlAddress(permanentKeyHash []byte, adnlAddress []byte, ttl uint32) error {
+	return c.expectSuccess("addValidatorAdnlAddress",
+		ControlAddValidatorAdnlAddress{PermanentKeyHash: permanentKeyHash, KeyHash: adnlAddress, Ttl: int32(ttl)})
+}
+
+func (c *ControlClient) DelValidatorPermanentKey(keyHash []byte) error {
+	return c.expectSuccess("delValidatorPermanentKey", ControlDelValidatorPermanentKey{KeyHash: keyHash})
+}
+
+func (c *ControlClient) DelValidatorTempKey(permanentKeyHash []byte, keyHash []byte) error {
+	return c.expectSuccess("delValidatorTempKey",
+		ControlDelValidatorTempKey{PermanentKeyHash: permanentKeyHash, KeyHash: keyHash})
+}
+
+func (c *ControlClient) DelValidatorAdnlAddress(permanentKeyHash []byte, adnlAddress []byte) error {
+	return c.expectSuccess("delValidatorAdnlAddress",
+		ControlDelValidatorAdnlAddress{PermanentKeyHash: permanentKeyHash, KeyHash: adnlAddress})
+}
+
+func (c *ControlClient) expectSuccess(name string, request tl.Serializable) error {
+	response, err := c.query(request)
+	if err != nil {
+		return err
+	}
+	if _, ok := response.(ControlSuccess); !ok {
+		return fmt.Errorf("unexpected response to %v: %T", name, response)
+	}
+	return nil
+}
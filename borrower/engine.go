@@ -4,8 +4,8 @@ import (
 	"context"
 	"encoding/base64"
 	"encoding/hex"
-	"encoding/json"
 	"fmt"
+	"log"
 	"os/exec"
 	"strconv"
 	"strings"
@@ -14,23 +14,43 @@ import (
 	"github.com/xssnick/tonutils-go/tvm/cell"
 )
 
+// Engine configures the validator engine, either directly over its control interface, or by running
+// validator-engine-console when the console protocol is configured or the control interface is not reachable.
 type Engine struct {
-	config ValidatorEngine
+	config  ValidatorEngine
+	control *ControlClient
 }
 
-type EngineConfig struct {
-	Validators []ValidatorConfig `json:"validators"`
+func NewValidatorEngine(config ValidatorEngine) *Engine {
+	engine := &Engine{
+		config: config,
+	}
+	if config.Protocol == "native" {
+		control, err := NewControlClient(config)
+		if err != nil {
+			log.Printf("   ⚠️  Falling back to validator-engine-console: %v", err)
+		} else {
+			engine.control = control
+		}
+	} else if config.Protocol != "" && config.Protocol != "console" {
+		panic(fmt.Sprintf("Error, invalid validator engine protocol, expected native or console but got: %v",
+			config.Protocol))
+	}
+	return engine
 }
 
-type ValidatorConfig struct {
-	Id           string `json:"id"`
-	ElectionDate uint32 `json:"election_date"`
+func (e *Engine) Close() {
+	if e.control != nil {
+		e.control.Close()
+	}
 }
 
-func NewValidatorEngine(config ValidatorEngine) *Engine {
-	return &Engine{
-		config: config,
+// Protocol is the protocol in use, which is console when the native protocol fell back to validator-engine-console.
+func (e *Engine) Protocol() string {
+	if e.control != nil {
+		return "native"
 	}
+	return "console"
 }
 
 func (e *Engine) createCommand(command string) ([]byte, error) {
@@ -44,6 +64,14 @@ func (e *Engine) createCommand(command string) ([]byte, error) {
 }
 
 func (e *Engine) IsSync() bool {
+	if e.control != nil {
+		stats, err := e.control.GetStats()
+		if err != nil {
+			panic(fmt.Sprintf("Error in validator engine getStats: %v", err))
+		}
+		return stats.UnixTime-stats.MasterchainBlockTime < 60
+	}
+
 	out, err := e.createCommand("getstats")
 	if err != nil {
 		panic(fmt.Sprintf("Error in validator-console getstats: %v", err))
@@ -59,33 +87,49 @@ func (e *Engine) IsSync() bool {
 	return unixTime-masterchainBlockTime < 60
 }
 
-func (e *Engine) FindPermKeyIfExists(roundSince uint32) (idHex string) {
-	out, err := e.createCommand("getconfig")
-	if err != nil {
-		panic(fmt.Sprintf("error in validator-console getconfig: %v", err))
+func (e *Engine) GetConfig() *EngineConfig {
+	var out []byte
+	if e.control != nil {
+		jsonString, err := e.control.GetConfig()
+		if err != nil {
+			panic(fmt.Sprintf("Error in validator engine getConfig: %v", err))
+		}
+		out = []byte(jsonString)
+	} else {
+		var err error
+		out, err = e.createCommand("getconfig")
+		if err != nil {
+			panic(fmt.Sprintf("Error in validator-console getconfig: %v", err))
+		}
 	}
-	lines := strings.Split(strings.Trim(string(out), " \n\t"), "\n")
-	lines = lines[5 : len(lines)-1]
-	jsonString := strings.Join(lines, "\n")
-	config := EngineConfig{}
-	err = json.Unmarshal([]byte(jsonString), &config)
+	config, err := ParseEngineConfig(out)
 	if err != nil {
-		panic(fmt.Sprintf("error in validator-console unmarshal of config: %v", err))
-	}
-	for _, vc := range config.Validators {
-		if vc.ElectionDate == roundSince {
-			bytes, err := base64.StdEncoding.DecodeString(vc.Id)
-			if err != nil {
-				panic(fmt.Sprintf("error in validator-console decode base64: %v", err))
-			}
-			idHex = hex.EncodeToString(bytes)
-			return
-		}
+		panic(fmt.Sprintf("Error in validator-console getconfig: %v", err))
+	}
+	return config
+}
+
+func (e *Engine) FindPermKeyIfExists(roundSince uint32) (idHex string) {
+	validator := e.GetConfig().FindValidator(roundSince)
+	if validator == nil {
+		return
+	}
+	idHex = validator.Id.Hex()
+	if idHex == "" {
+		panic(fmt.Sprintf("Error in validator-console decode base64: %v", validator.Id))
 	}
 	return
 }
 
 func (e *Engine) NewKey() string {
+	if e.control != nil {
+		keyHash, err := e.control.GenerateKeyPair()
+		if err != nil {
+			panic(fmt.Sprintf("Error in validator engine generateKeyPair: %v", err))
+		}
+		return hex.EncodeToString(keyHash)
+	}
+
 	out, err := e.createCommand("newkey")
 	if err != nil {
 		panic(fmt.Sprintf("Error in validator-console newkey: %v", err))
@@ -98,6 +142,14 @@ func (e *Engine) NewKey() string {
 }
 
 func (e *Engine) AddPermKey(keyHash string, roundSince uint32, expireAt uint32) {
+	if e.control != nil {
+		err := e.control.AddValidatorPermanentKey(decodeKeyHash(keyHash), roundSince, expireAt)
+		if err != nil {
+			panic(fmt.Sprintf("Error in validator engine addValidatorPermanentKey: %v", err))
+		}
+		return
+	}
+
 	out, err := e.createCommand(fmt.Sprintf("addpermkey %s %d %d", keyHash, roundSince, expireAt))
 	if err != nil {
 		panic(fmt.Sprintf("Error in validator-console addpermkey: %v", err))
@@ -108,6 +160,14 @@ func (e *Engine) AddPermKey(keyHash string, roundSince uint32, expireAt uint32)
 }
 
 func (e *Engine) AddTempKey(keyHash string, expireAt uint32) {
+	if e.control != nil {
+		err := e.control.AddValidatorTempKey(decodeKeyHash(keyHash), decodeKeyHash(keyHash), expireAt)
+		if err != nil {
+			panic(fmt.Sprintf("Error in validator engine addValidatorTempKey: %v", err))
+		}
+		return
+	}
+
 	out, err := e.createCommand(fmt.Sprintf("addtempkey %s %s %d", keyHash, keyHash, expireAt))
 	if err != nil {
 		panic(fmt.Sprintf("Error in validator-console addtempkey: %v", err))
@@ -118,6 +178,14 @@ func (e *Engine) AddTempKey(keyHash string, expireAt uint32) {
 }
 
 func (e *Engine) AddValidatorAddr(keyHash string, adnlAddress string, expireAt uint32) {
+	if e.control != nil {
+		err := e.control.AddValidatorAdnlAddress(decodeKeyHash(keyHash), decodeKeyHash(adnlAddress), expireAt)
+		if err != nil {
+			panic(fmt.Sprintf("Error in validator engine addValidatorAdnlAddress: %v", err))
+		}
+		return
+	}
+
 	out, err := e.createCommand(fmt.Sprintf("addvalidatoraddr %s %s %d", keyHash, adnlAddress, expireAt))
 	if err != nil {
 		panic(fmt.Sprintf("Error in validator-console addvalidatoraddr: %v", err))
@@ -127,7 +195,69 @@ func (e *Engine) AddValidatorAddr(keyHash string, adnlAddress string, expireAt u
 	}
 }
 
+func (e *Engine) DelPermKey(keyHash string) {
+	if e.control != nil {
+		err := e.control.DelValidatorPermanentKey(decodeKeyHash(keyHash))
+		if err != nil {
+			panic(fmt.Sprintf("Error in validator engine delValidatorPermanentKey: %v", err))
+		}
+		return
+	}
+
+	out, err := e.createCommand(fmt.Sprintf("delpermkey %s", keyHash))
+	if err != nil {
+		panic(fmt.Sprintf("Error in validator-console delpermkey: %v", err))
+	}
+	if getStatus(out) != "success" {
+		panic(fmt.Sprintf("Error in validator-console delpermkey: %s", out))
+	}
+}
+
+func (e *Engine) DelTempKey(permKeyHash string, keyHash string) {
+	if e.control != nil {
+		err := e.control.DelValidatorTempKey(decodeKeyHash(permKeyHash), decodeKeyHash(keyHash))
+		if err != nil {
+			panic(fmt.Sprintf("Error in validator engine delValidatorTempKey: %v", err))
+		}
+		return
+	}
+
+	out, err := e.createCommand(fmt.Sprintf("deltempkey %s %s", permKeyHash, keyHash))
+	if err != nil {
+		panic(fmt.Sprintf("Error in validator-console deltempkey: %v", err))
+	}
+	if getStatus(out) != "success" {
+		panic(fmt.Sprintf("Error in validator-console deltempkey: %s", out))
+	}
+}
+
+func (e *Engine) DelValidatorAddr(permKeyHash string, adnlAddress string) {
+	if e.control != nil {
+		err := e.control.DelValidatorAdnlAddress(decodeKeyHash(permKeyHash), decodeKeyHash(adnlAddress))
+		if err != nil {
+			panic(fmt.Sprintf("Error in validator engine delValidatorAdnlAddress: %v", err))
+		}
+		return
+	}
+
+	out, err := e.createCommand(fmt.Sprintf("delvalidatoraddr %s %s", permKeyHash, adnlAddress))
+	if err != nil {
+		panic(fmt.Sprintf("Error in validator-console delvalidatoraddr: %v", err))
+	}
+	if getStatus(out) != "success" {
+		panic(fmt.Sprintf("Error in validator-console delvalidatoraddr: %s", out))
+	}
+}
+
 func (e *Engine) ExportPub(keyHash string) []byte {
+	if e.control != nil {
+		publicKey, err := e.control.ExportPublicKey(decodeKeyHash(keyHash))
+		if err != nil {
+			panic(fmt.Sprintf("Error in validator engine exportPublicKey: %v", err))
+		}
+		return publicKey
+	}
+
 	out, err := e.createCommand(fmt.Sprintf("exportpub %s", keyHash))
 	if err != nil {
 		panic(fmt.Sprintf("Error in validator-console exportpub: %v", err))
@@ -147,6 +277,14 @@ func (e *Engine) ExportPub(keyHash string) []byte {
 }
 
 func (e *Engine) Sign(keyHash string, newStakeMsg *cell.Cell) []byte {
+	if e.control != nil {
+		signature, err := e.control.Sign(decodeKeyHash(keyHash), cellData(newStakeMsg))
+		if err != nil {
+			panic(fmt.Sprintf("Error in validator engine sign: %v", err))
+		}
+		return signature
+	}
+
 	message := newStakeMsg.Dump()
 	message = strings.Split(message, "[")[1]
 	message = strings.Split(message, "]")[0]
@@ -162,6 +300,14 @@ func (e *Engine) Sign(keyHash string, newStakeMsg *cell.Cell) []byte {
 	return signatureBytes
 }
 
+func decodeKeyHash(keyHash string) []byte {
+	bytes, err := hex.DecodeString(keyHash)
+	if err != nil || len(bytes) != 32 {
+		panic(fmt.Sprintf("Error, invalid key hash: %v", keyHash))
+	}
+	return bytes
+}
+
 func getLastTokenFromLine(out []byte, prefix string) string {
 	lines := strings.Split(string(out), "\n")
 	for _, line := range lines {
@@ -0,0 +1,134 @@
+package borrower
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+)
+
+// EngineConfig models the config of the validator engine, as returned by getconfig.
+type EngineConfig struct {
+	OutPort         int                    `json:"out_port"`
+	Addrs           []EngineAddr           `json:"addrs"`
+	Adnl            []EngineAdnl           `json:"adnl"`
+	Dht             []EngineDht            `json:"dht"`
+	Validators      []ValidatorConfig      `json:"validators"`
+	FullNode        EngineId               `json:"fullnode"`
+	FullNodeSlaves  []EngineFullNodeSlave  `json:"fullnodeslaves"`
+	FullNodeMasters []EngineFullNodeMaster `json:"fullnodemasters"`
+	FullNodeConfig  json.RawMessage        `json:"fullnodeconfig,omitempty"`
+	LiteServers     []EngineLiteServer     `json:"liteservers"`
+	Control         []EngineControl        `json:"control"`
+	Gc              EngineGc               `json:"gc"`
+}
+
+type EngineAddr struct {
+	Ip                 int32   `json:"ip"`
+	Port               int     `json:"port"`
+	Categories         []int32 `json:"categories"`
+	PriorityCategories []int32 `json:"priority_categories"`
+}
+
+type EngineAdnl struct {
+	Id       EngineId `json:"id"`
+	Category int32    `json:"category"`
+}
+
+type EngineDht struct {
+	Id EngineId `json:"id"`
+}
+
+type ValidatorConfig struct {
+	Id           EngineId               `json:"id"`
+	TempKeys     []ValidatorTempKey     `json:"temp_keys"`
+	AdnlAddrs    []ValidatorAdnlAddress `json:"adnl_addrs"`
+	ElectionDate uint32                 `json:"election_date"`
+	ExpireAt     uint32                 `json:"expire_at"`
+}
+
+type ValidatorTempKey struct {
+	Key      EngineId `json:"key"`
+	ExpireAt uint32   `json:"expire_at"`
+}
+
+type ValidatorAdnlAddress struct {
+	Id       EngineId `json:"id"`
+	ExpireAt uint32   `json:"expire_at"`
+}
+
+type EngineFullNodeSlave struct {
+	Ip   int32    `json:"ip"`
+	Port int      `json:"port"`
+	Adnl EngineId `json:"adnl"`
+}
+
+type EngineFullNodeMaster struct {
+	Port int      `json:"port"`
+	Adnl EngineId `json:"adnl"`
+}
+
+type EngineLiteServer struct {
+	Id   EngineId `json:"id"`
+	Port int      `json:"port"`
+}
+
+type EngineControl struct {
+	Id      EngineId               `json:"id"`
+	Port    int                    `json:"port"`
+	Allowed []EngineControlProcess `json:"allowed"`
+}
+
+type EngineControlProcess struct {
+	Id          EngineId `json:"id"`
+	Permissions int32    `json:"permissions"`
+}
+
+type EngineGc struct {
+	Ids []EngineId `json:"ids"`
+}
+
+// EngineId is a base64 encoded key hash or ADNL address, as written in the config of the engine.
+type EngineId string
+
+// Hex returns the id hex encoded, like the console and mytonctrl show it, or an empty string when it's invalid.
+func (id EngineId) Hex() string {
+	bytes, err := base64.StdEncoding.DecodeString(string(id))
+	if err != nil {
+		return ""
+	}
+	return hex.EncodeToString(bytes)
+}
+
+// FormatIp converts an IPv4 address stored as a signed integer in the config of the engine.
+func FormatIp(ip int32) string {
+	u := uint32(ip)
+	return net.IPv4(byte(u>>24), byte(u>>16), byte(u>>8), byte(u)).String()
+}
+
+// ParseEngineConfig finds the JSON object in the output of getconfig and parses it, ignoring any text around it.
+func ParseEngineConfig(out []byte) (*EngineConfig, error) {
+	start := bytes.IndexByte(out, '{')
+	if start < 0 {
+		return nil, errors.New("no JSON object found in engine config")
+	}
+	config := &EngineConfig{}
+	err := json.NewDecoder(bytes.NewReader(out[start:])).Decode(config)
+	if err != nil {
+		return nil, fmt.Errorf("invalid engine config: %w", err)
+	}
+	return config, nil
+}
+
+// FindValidator returns the validator with a permanent key for the election date.
+func (c *EngineConfig) FindValidator(electionDate uint32) *ValidatorConfig {
+	for i := range c.Validators {
+		if c.Validators[i].ElectionDate == electionDate {
+			return &c.Validators[i]
+		}
+	}
+	return nil
+}
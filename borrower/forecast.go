@@ -0,0 +1,258 @@
+package borrower
+
+import (
+	"context"
+	"fmt"
+	"math/big"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/xssnick/tonutils-go/address"
+	"github.com/xssnick/tonutils-go/tlb"
+	"github.com/xssnick/tonutils-go/ton"
+	"github.com/xssnick/tonutils-go/tvm/cell"
+)
+
+const defaultForecastRounds = 10
+
+// ForecastRound is the projected wallet balance around the loan request of one round.
+type ForecastRound struct {
+	Since     uint32
+	RequestAt uint32
+	Returned  *big.Int
+	Required  *big.Int
+	Before    *big.Int
+	After     *big.Int
+}
+
+// FundingForecast projects the wallet balance over the next rounds, assuming a loan request with the current borrow config
+// in every round. Stakes come back when their round is released, but rewards are not counted, and the fee and min
+// payment of every request are assumed to be spent, so the forecast is conservative.
+type FundingForecast struct {
+	Wallet  *address.Address
+	Balance *big.Int
+	Locked  *big.Int
+	Value   *big.Int
+	Rounds  []*ForecastRound
+	// RunOut is the request time of the first round that the wallet can't fund, or zero.
+	RunOut uint32
+	// TopUp is the amount to send to the wallet so that it funds every forecast round.
+	TopUp *big.Int
+}
+
+type forecastRelease struct {
+	at     uint32
+	amount *big.Int
+}
+
+func (f *FundingForecast) TopUpLink() string {
+	return topUpLink(f.Wallet, f.TopUp)
+}
+
+// topUpLink returns a ton:// link that wallet apps open as a transfer of amount to the non-bounceable address of the
+// wallet.
+func topUpLink(w *address.Address, amount *big.Int) string {
+	a := w.Bounce(false)
+	return fmt.Sprintf("ton://transfer/%v?amount=%v", a.String(), amount.String())
+}
+
+func loadForecast(config *Config, api ton.APIClientWrapped, ctx context.Context, mainchainInfo *ton.BlockIDExt,
+	treasuryAddress *address.Address, walletAddress *address.Address, balance *big.Int, rounds int) *FundingForecast {
+	validatorsElectedFor, minStake, _, nextRoundSince, stakeHeldFor := loadBlockchainConfig(api, ctx, mainchainInfo)
+	participations, _ := loadTreasuryState(api, ctx, mainchainInfo, treasuryAddress)
+	participateSince := getParticipateSince(api, ctx, mainchainInfo, treasuryAddress)
+
+	stake, loan, minPayment, _, _ := loadBorrowConfig(config.Borrow, minStake)
+	maxPunishment := getMaxPunishment(api, ctx, mainchainInfo, treasuryAddress, loan)
+	requestLoanFee :=
+		getRequestLoanFee(api, ctx, mainchainInfo, treasuryAddress, loadMaxRequestLoanFee(config.Borrow))
+	value := getRequestValue(maxPunishment, requestLoanFee, minPayment, stake)
+	returned := new(big.Int).Sub(value, requestLoanFee)
+	returned.Sub(returned, minPayment)
+
+	f := &FundingForecast{
+		Wallet:  walletAddress,
+		Balance: balance,
+		Locked:  big.NewInt(0),
+		Value:   value,
+		TopUp:   big.NewInt(0),
+	}
+
+	validatorKey := cell.BeginCell().MustStoreBigUInt(new(big.Int).SetBytes(walletAddress.Data()), 256).EndCell()
+	releases, requested := loadInFlight(participations, validatorKey, validatorsElectedFor, stakeHeldFor, returned)
+	for _, r := range releases {
+		f.Locked.Add(f.Locked, r.amount)
+	}
+
+	required := new(big.Int).Add(value, withdrawFeeMargin)
+	b := new(big.Int).Set(balance)
+	for i := 0; len(f.Rounds) < rounds; i++ {
+		since := nextRoundSince + uint32(i)*validatorsElectedFor
+		if requested[since] {
+			continue
+		}
+		r := &ForecastRound{
+			Since:     since,
+			RequestAt: participateSince + uint32(i)*validatorsElectedFor,
+			Returned:  big.NewInt(0),
+			Required:  required,
+		}
+		pending := []forecastRelease{}
+		for _, release := range releases {
+			if release.at <= r.RequestAt {
+				r.Returned.Add(r.Returned, release.amount)
+			} else {
+				pending = append(pending, release)
+			}
+		}
+		releases = pending
+		b.Add(b, r.Returned)
+		r.Before = new(big.Int).Set(b)
+
+		shortfall := new(big.Int).Sub(required, b)
+		if shortfall.Sign() > 0 {
+			if f.RunOut == 0 {
+				f.RunOut = r.RequestAt
+			}
+			if shortfall.Cmp(f.TopUp) > 0 {
+				f.TopUp = shortfall
+			}
+		}
+
+		b.Sub(b, value)
+		r.After = new(big.Int).Set(b)
+		releases = append(releases, forecastRelease{at: since + validatorsElectedFor + stakeHeldFor, amount: returned})
+		f.Rounds = append(f.Rounds, r)
+	}
+
+	return f
+}
+
+// loadInFlight returns when the stakes of the wallet in rounds tracked by the treasury are expected back, and which
+// rounds already have a request of the wallet. The stake of a request is known, and otherwise it's assumed to be
+// what a request with the current config returns.
+func loadInFlight(participations *cell.Dictionary, validatorKey *cell.Cell, validatorsElectedFor uint32,
+	stakeHeldFor uint32, returned *big.Int) ([]forecastRelease, map[uint32]bool) {
+	releases := []forecastRelease{}
+	requested := map[uint32]bool{}
+	if participations == nil {
+		return releases, requested
+	}
+	for _, kv := range participations.All() {
+		roundSince := uint32(kv.Key.BeginParse().MustLoadUInt(32))
+		participation := LoadParticipation(kv.Value)
+
+		amount := (*big.Int)(nil)
+		for _, d := range []*cell.Dictionary{participation.Requests, participation.Accepted} {
+			if d != nil && d.Get(validatorKey) != nil {
+				r := LoadRequest(d.Get(validatorKey))
+				amount = new(big.Int).Sub(r.StakeAmount, r.MinPayment)
+			}
+		}
+		for _, d := range []*cell.Dictionary{participation.Staked, participation.Recovering} {
+			if amount == nil && d != nil && d.Get(validatorKey) != nil {
+				amount = new(big.Int).Set(returned)
+			}
+		}
+		if amount == nil {
+			continue
+		}
+		requested[roundSince] = true
+
+		heldFor := participation.StakeHeldFor
+		if heldFor == 0 {
+			heldFor = stakeHeldFor
+		}
+		at := roundSince + validatorsElectedFor + heldFor
+		if participation.StakeHeldUntil != 0 {
+			at = participation.StakeHeldUntil
+		}
+		releases = append(releases, forecastRelease{at: at, amount: amount})
+	}
+	return releases, requested
+}
+
+// checkFunding warns when the forecast shows that the wallet can't fund a loan request within warn_before.
+func checkFunding(config *Config, api ton.APIClientWrapped, ctx context.Context, mainchainInfo *ton.BlockIDExt,
+	store *Store, treasuryAddress *address.Address, walletAddress *address.Address, balance *big.Int) {
+	if config.Forecast.WarnBefore == 0 {
+		return
+	}
+	rounds := config.Forecast.Rounds
+	if rounds <= 0 {
+		rounds = defaultForecastRounds
+	}
+	f := loadForecast(config, api, ctx, mainchainInfo, treasuryAddress, walletAddress, balance, rounds)
+	if f.RunOut == 0 {
+		return
+	}
+	until := time.Until(time.Unix(int64(f.RunOut), 0))
+	if until > config.Forecast.WarnBefore {
+		return
+	}
+	alerter := newAlerter(config.Alerts, store)
+	level := AlertWarning
+	if until <= 0 {
+		level = AlertCritical
+	}
+	alerter.raise("funding", level, "Wallet funding runs out at %v, top up %v TON with %v",
+		time.Unix(int64(f.RunOut), 0).Format(TimeFormat), tlb.FromNanoTON(f.TopUp).String(), f.TopUpLink())
+}
+
+// PrintForecast prints the projected wallet balance over the next rounds, when funding runs out, and how much to
+// top up.
+func PrintForecast(rounds int) error {
+	return runCommand(func() {
+		config := loadConfig()
+		if rounds <= 0 {
+			rounds = config.Forecast.Rounds
+		}
+		if rounds <= 0 {
+			rounds = defaultForecastRounds
+		}
+
+		api, ctx := loadApi(config)
+
+		w := loadWallet(config.Wallet, api)
+
+		treasuryAddress := address.MustParseAddr(config.Treasury)
+
+		mainchainInfo := loadMainchainInfo(api, ctx)
+
+		balance := loadBalance(w, mainchainInfo)
+
+		f := loadForecast(config, api, ctx, mainchainInfo, treasuryAddress, w.Address(), balance, rounds)
+
+		tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintf(tw, "Wallet:\t%v\n", w.Address().String())
+		fmt.Fprintf(tw, "Balance:\t%v TON\n", tlb.FromNanoTON(f.Balance).String())
+		fmt.Fprintf(tw, "Locked in rounds:\t%v TON\n", tlb.FromNanoTON(f.Locked).String())
+		fmt.Fprintf(tw, "Request value:\t%v TON\n", tlb.FromNanoTON(f.Value).String())
+		tw.Flush()
+		fmt.Println()
+
+		tw = tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(tw, "ROUND\tREQUEST AT\tRETURNED\tBEFORE\tREQUIRED\tAFTER\t")
+		for _, r := range f.Rounds {
+			funded := ""
+			if r.Before.Cmp(r.Required) < 0 {
+				funded = "not funded"
+			}
+			fmt.Fprintf(tw, "%v\t%v\t%v\t%v\t%v\t%v\t%v\n",
+				time.Unix(int64(r.Since), 0).Format(TimeFormat), time.Unix(int64(r.RequestAt), 0).Format(TimeFormat),
+				tlb.FromNanoTON(r.Returned).String(), tlb.FromNanoTON(r.Before).String(),
+				tlb.FromNanoTON(r.Required).String(), tlb.FromNanoTON(r.After).String(), funded)
+		}
+		tw.Flush()
+		fmt.Println()
+
+		if f.RunOut == 0 {
+			fmt.Printf("The wallet funds the next %v rounds\n", len(f.Rounds))
+			return
+		}
+		fmt.Printf("Funding runs out at %v\n", time.Unix(int64(f.RunOut), 0).Format(TimeFormat))
+		fmt.Printf("Top up %v TON to fund the next %v rounds:\n", tlb.FromNanoTON(f.TopUp).String(), len(f.Rounds))
+		fmt.Println(f.TopUpLink())
+	})
+}
@@ -0,0 +1,474 @@
+package borrower
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"math/big"
+	"os"
+	"sort"
+	"strconv"
+	"text/tabwriter"
+	"time"
+
+	"github.com/xssnick/tonutils-go/address"
+	"github.com/xssnick/tonutils-go/tl"
+	"github.com/xssnick/tonutils-go/tlb"
+	"github.com/xssnick/tonutils-go/ton"
+	"github.com/xssnick/tonutils-go/tvm/cell"
+)
+
+var HistoryFile = "history.json"
+
+type HistoryRequestStatus string
+
+const (
+	HistoryRequested HistoryRequestStatus = "requested"
+	HistoryAccepted  HistoryRequestStatus = "accepted"
+	HistoryRejected  HistoryRequestStatus = "rejected"
+	HistoryUnknown   HistoryRequestStatus = "unknown"
+)
+
+// History is the local database of past rounds of the treasury, built by the index command from the transactions
+// of the treasury and snapshots of its state.
+type History struct {
+	path       string
+	TreasuryLt uint64          `json:"treasury_lt"`
+	Rounds     []*HistoryRound `json:"rounds"`
+}
+
+// HistoryRound is a round of the treasury with the loan requests of every validator. The snapshot fields come from
+// the last state of the round that was seen, which is final when Complete is set. DistributedAt is the time of the
+// first keeper message after the loans of the round were distributed.
+type HistoryRound struct {
+	Round          uint32            `json:"round"`
+	Requests       []*HistoryRequest `json:"requests"`
+	DistributedAt  uint32            `json:"distributed_at,omitempty"`
+	State          string            `json:"state,omitempty"`
+	Size           uint16            `json:"size"`
+	TotalStaked    tlb.Coins         `json:"total_staked"`
+	TotalRecovered tlb.Coins         `json:"total_recovered"`
+	SnapshotAt     uint32            `json:"snapshot_at,omitempty"`
+	Complete       bool              `json:"complete"`
+	Error          string            `json:"error,omitempty"`
+}
+
+// HistoryRequest is the last loan request of a validator in a round.
+type HistoryRequest struct {
+	Validator            string               `json:"validator"`
+	At                   uint32               `json:"at"`
+	Lt                   uint64               `json:"lt"`
+	QueryId              uint64               `json:"query_id"`
+	Value                tlb.Coins            `json:"value"`
+	Loan                 tlb.Coins            `json:"loan"`
+	MinPayment           tlb.Coins            `json:"min_payment"`
+	ValidatorRewardShare uint8                `json:"validator_reward_share"`
+	MaxFactor            uint32               `json:"max_factor"`
+	Updates              int                  `json:"updates"`
+	Status               HistoryRequestStatus `json:"status"`
+}
+
+// historyDepth is how far back the first index goes.
+const historyDepth = 90 * 24 * time.Hour
+
+func loadHistory(config *Config) *History {
+	path := dataPath(config, HistoryFile)
+	history := &History{}
+	err := readJson(path, history)
+	if err != nil {
+		panic(fmt.Sprintf("Error in reading history: %v", err))
+	}
+	history.path = path
+	return history
+}
+
+func (h *History) save() {
+	err := writeJson(h.path, h)
+	if err != nil {
+		panic(fmt.Sprintf("Error in writing history: %v", err))
+	}
+}
+
+func (h *History) round(roundSince uint32) *HistoryRound {
+	for _, r := range h.Rounds {
+		if r.Round == roundSince {
+			return r
+		}
+	}
+	return nil
+}
+
+func (h *History) addRound(roundSince uint32) *HistoryRound {
+	r := h.round(roundSince)
+	if r == nil {
+		r = &HistoryRound{Round: roundSince}
+		h.Rounds = append(h.Rounds, r)
+		sort.Slice(h.Rounds, func(i, j int) bool { return h.Rounds[i].Round < h.Rounds[j].Round })
+	}
+	return r
+}
+
+// requestsOf returns the requests of a validator in every round, by the raw form of its address.
+func (h *History) requestsOf(validator string) []*HistoryRequest {
+	requests := []*HistoryRequest{}
+	for _, r := range h.Rounds {
+		for _, q := range r.Requests {
+			if q.Validator == validator {
+				requests = append(requests, q)
+			}
+		}
+	}
+	return requests
+}
+
+// addRequest records a loan request of a validator, which replaces the earlier request of the validator in the
+// round, like it does in the treasury.
+func (r *HistoryRound) addRequest(q *HistoryRequest) {
+	for i, existing := range r.Requests {
+		if existing.Validator == q.Validator {
+			if existing.Lt >= q.Lt {
+				return
+			}
+			q.Updates = existing.Updates + 1
+			r.Requests[i] = q
+			return
+		}
+	}
+	r.Requests = append(r.Requests, q)
+}
+
+// snapshot records the state of the participation of the round, and which requests were accepted or rejected.
+// Once the round is past open, a request that is in none of the dictionaries can't be classified, since the treasury
+// may have already removed it, so it's unknown unless an earlier snapshot classified it.
+func (r *HistoryRound) snapshot(participation *Participation, at uint32) {
+	r.State = participation.State.String()
+	r.Size = participation.Size
+	r.TotalStaked = tlb.FromNanoTON(participation.TotalStaked)
+	r.TotalRecovered = tlb.FromNanoTON(participation.TotalRecovered)
+	r.SnapshotAt = at
+	r.Error = ""
+	for _, q := range r.Requests {
+		validatorKey := cell.BeginCell().
+			MustStoreBigUInt(new(big.Int).SetBytes(parseAnyAddress(q.Validator).Data()), 256).EndCell()
+		if dictionaryHas(participation.Accepted, validatorKey) || dictionaryHas(participation.Staked, validatorKey) ||
+			dictionaryHas(participation.Recovering, validatorKey) {
+			q.Status = HistoryAccepted
+		} else if dictionaryHas(participation.Rejected, validatorKey) {
+			q.Status = HistoryRejected
+		} else if participation.State != ParticipationOpen && q.Status == HistoryRequested {
+			q.Status = HistoryUnknown
+		}
+	}
+}
+
+func dictionaryHas(d *cell.Dictionary, key *cell.Cell) bool {
+	return d != nil && d.Get(key) != nil
+}
+
+// parseLoanRequestBody reads a request_loan message, which has the round and the max factor of the validator in its
+// new stake message.
+func parseLoanRequestBody(body *cell.Cell) (*HistoryRequest, uint32, error) {
+	if body == nil {
+		return nil, 0, errors.New("empty body")
+	}
+	s := body.BeginParse()
+	op, err := s.LoadUInt(32)
+	if err != nil || op != LoanRequest {
+		return nil, 0, errors.New("not a loan request")
+	}
+	q := &HistoryRequest{Status: HistoryRequested}
+	q.QueryId, err = s.LoadUInt(64)
+	if err != nil {
+		return nil, 0, err
+	}
+	round, err := s.LoadUInt(32)
+	if err != nil {
+		return nil, 0, err
+	}
+	loan, err := s.LoadBigCoins()
+	if err != nil {
+		return nil, 0, err
+	}
+	minPayment, err := s.LoadBigCoins()
+	if err != nil {
+		return nil, 0, err
+	}
+	share, err := s.LoadUInt(8)
+	if err != nil {
+		return nil, 0, err
+	}
+	q.Loan = tlb.FromNanoTON(loan)
+	q.MinPayment = tlb.FromNanoTON(minPayment)
+	q.ValidatorRewardShare = uint8(share)
+	newStakeMsg, err := s.LoadRef()
+	if err == nil {
+		_, err = newStakeMsg.LoadBigUInt(256)
+		if err == nil {
+			_, err = newStakeMsg.LoadUInt(32)
+		}
+		if err == nil {
+			maxFactor, err := newStakeMsg.LoadUInt(32)
+			if err == nil {
+				q.MaxFactor = uint32(maxFactor)
+			}
+		}
+	}
+	return q, uint32(round), nil
+}
+
+// indexTreasury records the loan requests and keeper messages in the treasury transactions since the last index,
+// and takes snapshots of the treasury state for rounds that aren't complete yet. Rounds that are still tracked by
+// the treasury take the current state, and finished rounds take the state at their first keeper message after
+// distribution, when every stake is still in the staked dictionary, which needs a liteserver with archive states for
+// old rounds.
+func indexTreasury(api ton.APIClientWrapped, ctx context.Context, mainchainInfo *ton.BlockIDExt, history *History,
+	treasuryAddress *address.Address, depth time.Duration) {
+	account := func() *tlb.Account {
+		ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+		defer cancel()
+		account, err := api.GetAccount(ctx, mainchainInfo, treasuryAddress)
+		if err != nil {
+			panic(fmt.Sprintf("Error in getting treasury account: %v", err))
+		}
+		if !account.IsActive {
+			panic("Error, treasury account is not active")
+		}
+		return account
+	}()
+
+	cutoff := uint32(time.Now().Add(-depth).Unix())
+	pages := 0
+	transactions := listTransactions(api, ctx, account, treasuryAddress, history.TreasuryLt,
+		func(oldest *tlb.Transaction) bool {
+			pages++
+			if pages%50 == 0 {
+				log.Printf("🔎 Scanned %v treasury transactions, at %v", pages*transactionsPage,
+					time.Unix(int64(oldest.Now), 0).Format(TimeFormat))
+			}
+			return history.TreasuryLt == 0 && oldest.Now < cutoff
+		})
+
+	requests := 0
+	for _, tx := range transactions {
+		if e := loadTreasuryExternal(tx); e != nil {
+			if e.success && isKeeperOp(e.op) && e.op != ParticipateInElection {
+				r := history.addRound(e.round)
+				if r.DistributedAt == 0 || tx.Now < r.DistributedAt {
+					r.DistributedAt = tx.Now
+				}
+			}
+			continue
+		}
+		if tx.IO.In == nil || tx.IO.In.MsgType != tlb.MsgTypeInternal || !isTransactionSuccessful(tx) {
+			continue
+		}
+		in := tx.IO.In.AsInternal()
+		if in.Bounced {
+			continue
+		}
+		q, round, err := parseLoanRequestBody(in.Payload())
+		if err != nil {
+			continue
+		}
+		q.Validator = in.SrcAddr.StringRaw()
+		q.At = tx.Now
+		q.Lt = tx.LT
+		q.Value = in.Amount
+		history.addRound(round).addRequest(q)
+		requests++
+	}
+	if len(transactions) > 0 {
+		history.TreasuryLt = transactions[len(transactions)-1].LT
+	}
+	log.Printf("🔎 Indexed %v treasury transactions with %v loan requests", len(transactions), requests)
+
+	participations, _ := loadTreasuryState(api, ctx, mainchainInfo, treasuryAddress)
+	current := map[uint32]*Participation{}
+	if participations != nil {
+		for _, kv := range participations.All() {
+			participation := LoadParticipation(kv.Value)
+			current[uint32(kv.Key.BeginParse().MustLoadUInt(32))] = &participation
+		}
+	}
+
+	for _, r := range history.Rounds {
+		if r.Complete {
+			continue
+		}
+		if participation, ok := current[r.Round]; ok {
+			r.snapshot(participation, uint32(time.Now().Unix()))
+			continue
+		}
+		if r.DistributedAt == 0 {
+			continue
+		}
+		err := snapshotPastRound(api, ctx, r, treasuryAddress)
+		if err != nil {
+			r.Error = err.Error()
+			log.Printf("⚠️  Failed to take a snapshot of round %v: %v",
+				time.Unix(int64(r.Round), 0).Format(TimeFormat), err)
+			continue
+		}
+		r.Complete = true
+	}
+}
+
+func isKeeperOp(op uint32) bool {
+	return op == ParticipateInElection || op == VsetChanged || op == FinishParticipation
+}
+
+// snapshotPastRound takes the state of the treasury in the last masterchain block at the first keeper message after
+// distribution of a finished round. The round is staked or validating then, both with the final stakes.
+func snapshotPastRound(api ton.APIClientWrapped, ctx context.Context, r *HistoryRound,
+	treasuryAddress *address.Address) (err error) {
+	defer func() {
+		if e := recover(); e != nil {
+			err = fmt.Errorf("%v", e)
+		}
+	}()
+
+	block, err := lookupMasterchainBlockByTime(api, ctx, r.DistributedAt)
+	if err != nil {
+		return fmt.Errorf("error in looking up block: %w", err)
+	}
+	participations, _ := loadTreasuryState(api, ctx, block, treasuryAddress)
+	if participations == nil {
+		return errors.New("round is not in treasury state")
+	}
+	key := cell.BeginCell().MustStoreUInt(uint64(r.Round), 32).EndCell()
+	value := participations.Get(key)
+	if value == nil {
+		return errors.New("round is not in treasury state")
+	}
+	participation := LoadParticipation(value)
+	r.snapshot(&participation, r.DistributedAt)
+	return nil
+}
+
+// lookupMasterchainBlockByTime returns the last masterchain block created at or before utime.
+func lookupMasterchainBlockByTime(api ton.APIClientWrapped, ctx context.Context, utime uint32) (
+	*ton.BlockIDExt, error) {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	var resp tl.Serializable
+	err := api.Client().QueryLiteserver(ctx, ton.LookupBlock{
+		Mode:  4,
+		ID:    &ton.BlockInfoShort{Workchain: address.MasterchainID, Shard: -0x8000000000000000},
+		UTime: utime,
+	}, &resp)
+	if err != nil {
+		return nil, err
+	}
+	switch t := resp.(type) {
+	case ton.BlockHeader:
+		return t.ID, nil
+	case ton.LSError:
+		return nil, t
+	}
+	return nil, fmt.Errorf("unexpected response %T", resp)
+}
+
+// Index updates the local history of the treasury, going back depth on the first run.
+func Index(depth time.Duration) error {
+	return runCommand(func() {
+		config := loadConfig()
+		if depth == 0 {
+			depth = historyDepth
+		}
+
+		api, ctx := loadApi(config)
+
+		history := loadHistory(config)
+
+		treasuryAddress := address.MustParseAddr(config.Treasury)
+
+		mainchainInfo := loadMainchainInfo(api, ctx)
+
+		indexTreasury(api, ctx, mainchainInfo, history, treasuryAddress, depth)
+
+		history.save()
+
+		complete := 0
+		for _, r := range history.Rounds {
+			if r.Complete {
+				complete++
+			}
+		}
+		fmt.Printf("Indexed %v rounds, %v of them complete, in %v\n", len(history.Rounds), complete, history.path)
+	})
+}
+
+// PrintHistory prints the loan requests in the local history, of a round, or of a validator, or both.
+func PrintHistory(round string, validator string, format string) error {
+	return runCommand(func() {
+		config := loadConfig()
+
+		history := loadHistory(config)
+
+		roundSince := uint32(0)
+		if round != "" {
+			r, err := strconv.ParseUint(round, 10, 32)
+			if err != nil {
+				panic(fmt.Sprintf("Error, invalid round, expected the unix time of its start but got: %v", round))
+			}
+			roundSince = uint32(r)
+		}
+		validatorRaw := ""
+		if validator != "" {
+			validatorRaw = parseAnyAddress(validator).StringRaw()
+		}
+
+		type row struct {
+			*HistoryRequest
+			Round uint32 `json:"round"`
+		}
+		rows := []row{}
+		for _, r := range history.Rounds {
+			if roundSince != 0 && r.Round != roundSince {
+				continue
+			}
+			for _, q := range r.Requests {
+				if validatorRaw == "" || q.Validator == validatorRaw {
+					rows = append(rows, row{HistoryRequest: q, Round: r.Round})
+				}
+			}
+		}
+
+		switch format {
+		case "json":
+			printJson(rows)
+		case "csv":
+			w := csv.NewWriter(os.Stdout)
+			w.Write([]string{"round", "validator", "at", "value", "loan", "min_payment", "validator_reward_share",
+				"max_factor", "updates", "status"})
+			for _, q := range rows {
+				w.Write([]string{strconv.FormatUint(uint64(q.Round), 10), q.Validator,
+					strconv.FormatUint(uint64(q.At), 10), q.Value.String(), q.Loan.String(), q.MinPayment.String(),
+					strconv.Itoa(int(q.ValidatorRewardShare)), strconv.FormatUint(uint64(q.MaxFactor), 10),
+					strconv.Itoa(q.Updates), string(q.Status)})
+			}
+			w.Flush()
+		default:
+			tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+			fmt.Fprintln(tw, "ROUND\tVALIDATOR\tVALUE\tLOAN\tMIN PAYMENT\tSHARE\tMAX FACTOR\tSTATUS")
+			for _, q := range rows {
+				fmt.Fprintf(tw, "%v (%v)\t%v\t%v\t%v\t%v\t%v\t%.2f\t%v\n",
+					time.Unix(int64(q.Round), 0).Format(TimeFormat), q.Round, shortHex(q.Validator), q.Value.String(),
+					q.Loan.String(), q.MinPayment.String(), q.ValidatorRewardShare, float64(q.MaxFactor)/65536,
+					q.Status)
+			}
+			tw.Flush()
+		}
+	})
+}
+
+// shortHex shortens the hex part of a raw address for tables.
+func shortHex(raw string) string {
+	a := parseAnyAddress(raw)
+	h := hex.EncodeToString(a.Data())
+	return fmt.Sprintf("%v:%v…%v", a.Workchain(), h[:6], h[len(h)-6:])
+}
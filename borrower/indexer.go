@@ -0,0 +1,353 @@
+package borrower
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"strconv"
+	"text/tabwriter"
+	"time"
+
+	"github.com/xssnick/tonutils-go/address"
+	"github.com/xssnick/tonutils-go/tlb"
+	"github.com/xssnick/tonutils-go/ton"
+)
+
+type WalletTransferKind string
+
+const (
+	WalletTransferRequestLoan WalletTransferKind = "request_loan"
+	WalletTransferReturn      WalletTransferKind = "return"
+	WalletTransferBounce      WalletTransferKind = "bounce"
+	WalletTransferSweep       WalletTransferKind = "sweep"
+	WalletTransferOther       WalletTransferKind = "transfer"
+)
+
+// WalletTransfer is a message that the wallet received or sent, linked to a round when it belongs to one.
+type WalletTransfer struct {
+	Lt           uint64             `json:"lt"`
+	Hash         string             `json:"hash"`
+	At           int64              `json:"at"`
+	Direction    string             `json:"direction"`
+	Kind         WalletTransferKind `json:"kind"`
+	Counterparty string             `json:"counterparty"`
+	Amount       tlb.Coins          `json:"amount"`
+	Op           uint32             `json:"op"`
+	QueryId      uint64             `json:"query_id"`
+	Round        uint32             `json:"round,omitempty"`
+}
+
+// Unreconciled is a round with a loan request, but without any TON back after it was released.
+type Unreconciled struct {
+	Round     uint32 `json:"round"`
+	ReleaseAt int64  `json:"release_at"`
+	Sent      string `json:"sent"`
+	Reason    string `json:"reason"`
+}
+
+const (
+	// walletIndexHistory is how far back the first indexing goes, when there's no recorded loan request.
+	walletIndexHistory = 90 * 24 * time.Hour
+	walletHistoryKeep  = 365 * 24 * time.Hour
+	// reconcileGrace is the time after the release of a round that its return may take.
+	reconcileGrace = 1 * time.Hour
+	// reconcileHistory limits reconciliation to rounds released recently.
+	reconcileHistory = 30 * 24 * time.Hour
+)
+
+// indexWallet walks the wallet transactions since the last indexed one, records every message in the wallet
+// history, links loan requests and returns to rounds, and records returns in the accounts of their rounds. A round is
+// released releaseAfter seconds after it starts, which is validators_elected_for plus stake_held_for.
+func indexWallet(config *Config, api ton.APIClientWrapped, ctx context.Context, mainchainInfo *ton.BlockIDExt,
+	store *Store, walletAddress *address.Address, treasuryAddress *address.Address, releaseAfter uint32) {
+
+	var sweepAddress *address.Address
+	if config.Sweep.Address != "" {
+		sweepAddress = parseAnyAddress(config.Sweep.Address)
+	}
+
+	since := store.WalletLt
+	cutoff := time.Now().Add(-walletIndexHistory).Unix()
+	if since == 0 {
+		for _, a := range store.Accounts {
+			for _, r := range a.Requests {
+				if r.SentAt < cutoff {
+					cutoff = r.SentAt
+				}
+			}
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, 60*time.Second)
+	defer cancel()
+
+	account, err := api.GetAccount(ctx, mainchainInfo, walletAddress)
+	if err != nil {
+		panic(fmt.Sprintf("Error in getting wallet account: %v", err))
+	}
+	if !account.IsActive || account.LastTxLT <= since {
+		return
+	}
+
+	transactions := listTransactions(api, ctx, account, walletAddress, since, func(oldest *tlb.Transaction) bool {
+		return since == 0 && int64(oldest.Now) < cutoff
+	})
+
+	for _, tx := range transactions {
+		if tx.IO.In != nil && tx.IO.In.MsgType == tlb.MsgTypeInternal {
+			store.indexIncoming(tx, tx.IO.In.AsInternal(), treasuryAddress)
+		}
+		if tx.IO.Out == nil {
+			continue
+		}
+		messages, err := tx.IO.Out.ToSlice()
+		if err != nil {
+			panic(fmt.Sprintf("Error in loading outgoing messages of wallet transaction: %v", err))
+		}
+		for _, m := range messages {
+			if m.MsgType == tlb.MsgTypeInternal {
+				store.indexOutgoing(tx, m.AsInternal(), sweepAddress, releaseAfter)
+			}
+		}
+	}
+	store.WalletLt = account.LastTxLT
+	store.pruneWalletHistory()
+	store.save()
+}
+
+func (s *Store) indexIncoming(tx *tlb.Transaction, m *tlb.InternalMessage, treasuryAddress *address.Address) {
+	op, queryId, round := parseResponseBody(m)
+	t := &WalletTransfer{
+		Lt:           tx.LT,
+		Hash:         hex.EncodeToString(tx.Hash),
+		At:           int64(tx.Now),
+		Direction:    "in",
+		Kind:         WalletTransferOther,
+		Counterparty: m.SrcAddr.String(),
+		Amount:       m.Amount,
+		Op:           op,
+		QueryId:      queryId,
+	}
+	if a := s.attribute(m.SrcAddr, treasuryAddress, queryId, round, t.At); a != nil {
+		t.Kind = WalletTransferReturn
+		t.Round = a.Round
+		a.addReturn(&LoanReturn{
+			At:     t.At,
+			Lt:     t.Lt,
+			Hash:   t.Hash,
+			From:   t.Counterparty,
+			Op:     op,
+			Amount: m.Amount,
+		})
+	}
+	if m.Bounced {
+		t.Kind = WalletTransferBounce
+	}
+	s.WalletHistory = append(s.WalletHistory, t)
+}
+
+func (s *Store) indexOutgoing(tx *tlb.Transaction, m *tlb.InternalMessage, sweepAddress *address.Address,
+	lockedFor uint32) {
+	op, queryId, round := parseResponseBody(m)
+	t := &WalletTransfer{
+		Lt:           tx.LT,
+		Hash:         hex.EncodeToString(tx.Hash),
+		At:           int64(tx.Now),
+		Direction:    "out",
+		Kind:         WalletTransferOther,
+		Counterparty: m.DstAddr.String(),
+		Amount:       m.Amount,
+		Op:           op,
+		QueryId:      queryId,
+	}
+	if op == LoanRequest && round != 0 {
+		t.Kind = WalletTransferRequestLoan
+		t.Round = round
+		s.linkLoanRequest(round, int64(round+lockedFor), &LoanRequestRecord{
+			QueryId: queryId,
+			SentAt:  t.At,
+			Lt:      t.Lt,
+			Hash:    t.Hash,
+			Value:   m.Amount,
+		})
+	} else if sweepAddress != nil && m.DstAddr.StringRaw() == sweepAddress.StringRaw() {
+		t.Kind = WalletTransferSweep
+	}
+	s.WalletHistory = append(s.WalletHistory, t)
+}
+
+// linkLoanRequest adds a loan request found in the wallet history to the account of its round, unless it's already
+// recorded. Requests that weren't recorded when they were sent only have their total value.
+func (s *Store) linkLoanRequest(roundSince uint32, releaseAt int64, r *LoanRequestRecord) {
+	a := s.account(roundSince)
+	if a == nil {
+		a = &RoundAccount{Round: roundSince, ReleaseAt: releaseAt}
+		s.Accounts = append(s.Accounts, a)
+	}
+	for _, existing := range a.Requests {
+		if existing.QueryId == r.QueryId {
+			return
+		}
+	}
+	a.Requests = append(a.Requests, r)
+}
+
+func (a *RoundAccount) addReturn(r *LoanReturn) {
+	for _, existing := range a.Returns {
+		if existing.Lt == r.Lt {
+			return
+		}
+	}
+	a.Returns = append(a.Returns, r)
+}
+
+// pruneWalletHistory forgets wallet messages from a long time ago.
+func (s *Store) pruneWalletHistory() {
+	cutoff := time.Now().Add(-walletHistoryKeep).Unix()
+	history := []*WalletTransfer{}
+	for _, t := range s.WalletHistory {
+		if t.At > cutoff {
+			history = append(history, t)
+		}
+	}
+	s.WalletHistory = history
+}
+
+// reconcile returns the recently released rounds with a loan request but nothing back in the wallet.
+func (s *Store) reconcile(now int64) []*Unreconciled {
+	unreconciled := []*Unreconciled{}
+	for _, a := range s.Accounts {
+		if len(a.Requests) == 0 || len(a.Returns) > 0 {
+			continue
+		}
+		if now < a.ReleaseAt+int64(reconcileGrace.Seconds()) ||
+			now > a.ReleaseAt+int64(reconcileHistory.Seconds()) {
+			continue
+		}
+		p := a.pnl(now)
+		unreconciled = append(unreconciled, &Unreconciled{
+			Round:     a.Round,
+			ReleaseAt: a.ReleaseAt,
+			Sent:      p.Sent,
+			Reason:    "no refund or return since the round was released",
+		})
+	}
+	return unreconciled
+}
+
+// parseResponseBody reads the op, query id, and round of a message body, when it has them. Bounced messages have
+// the body of the original message after a 0xffffffff prefix.
+func parseResponseBody(m *tlb.InternalMessage) (op uint32, queryId uint64, round uint32) {
+	body := m.Payload()
+	if body == nil {
+		return
+	}
+	s := body.BeginParse()
+	o, err := s.LoadUInt(32)
+	if err != nil {
+		return
+	}
+	if o == 0xffffffff {
+		o, err = s.LoadUInt(32)
+		if err != nil {
+			return
+		}
+	}
+	op = uint32(o)
+	q, err := s.LoadUInt(64)
+	if err != nil {
+		return
+	}
+	queryId = q
+	r, err := s.LoadUInt(32)
+	if err != nil {
+		return
+	}
+	round = uint32(r)
+	return
+}
+
+// reconcileWallet indexes the wallet history and raises an alert for every unreconciled round.
+func reconcileWallet(config *Config, api ton.APIClientWrapped, ctx context.Context, mainchainInfo *ton.BlockIDExt,
+	store *Store, walletAddress *address.Address, treasuryAddress *address.Address, releaseAfter uint32) {
+	indexWallet(config, api, ctx, mainchainInfo, store, walletAddress, treasuryAddress, releaseAfter)
+
+	alerter := newAlerter(config.Alerts, store)
+	for _, u := range store.reconcile(time.Now().Unix()) {
+		alerter.raise(fmt.Sprintf("unreconciled:%v", u.Round), AlertWarning,
+			"Round %v sent %v TON in loan requests, but has %v",
+			time.Unix(int64(u.Round), 0).Format(TimeFormat), u.Sent, u.Reason)
+	}
+}
+
+// ReportWallet prints the indexed wallet history between from and to, and the unreconciled rounds, as a table, CSV,
+// or JSON.
+func ReportWallet(from string, to string, format string) error {
+	return runCommand(func() {
+		config := loadConfig()
+
+		fromTime, toTime := parseReportRange(from, to)
+
+		api, ctx := loadApi(config)
+
+		store := loadStore(config)
+
+		treasuryAddress := address.MustParseAddr(config.Treasury)
+
+		mainchainInfo := loadMainchainInfo(api, ctx)
+
+		validatorsElectedFor, _, _, _, stakeHeldFor := loadBlockchainConfig(api, ctx, mainchainInfo)
+
+		indexWallet(config, api, ctx, mainchainInfo, store, loadWalletAddress(config.Wallet), treasuryAddress,
+			validatorsElectedFor+stakeHeldFor)
+
+		history := []*WalletTransfer{}
+		for _, t := range store.WalletHistory {
+			at := time.Unix(t.At, 0)
+			if !at.Before(fromTime) && at.Before(toTime) {
+				history = append(history, t)
+			}
+		}
+		unreconciled := store.reconcile(time.Now().Unix())
+
+		switch format {
+		case "json":
+			printJson(map[string]any{"history": history, "unreconciled": unreconciled})
+		case "csv":
+			w := csv.NewWriter(os.Stdout)
+			w.Write([]string{"lt", "hash", "at", "direction", "kind", "counterparty", "amount", "op", "query_id",
+				"round"})
+			for _, t := range history {
+				w.Write([]string{strconv.FormatUint(t.Lt, 10), t.Hash, strconv.FormatInt(t.At, 10), t.Direction,
+					string(t.Kind), t.Counterparty, t.Amount.String(), OpName(t.Op),
+					strconv.FormatUint(t.QueryId, 10), strconv.FormatUint(uint64(t.Round), 10)})
+			}
+			w.Flush()
+		default:
+			tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+			fmt.Fprintln(tw, "AT\tDIRECTION\tKIND\tAMOUNT\tCOUNTERPARTY\tOP\tROUND\tTRANSACTION")
+			for _, t := range history {
+				round := "-"
+				if t.Round != 0 {
+					round = time.Unix(int64(t.Round), 0).Format(TimeFormat)
+				}
+				fmt.Fprintf(tw, "%v\t%v\t%v\t%v\t%v\t%v\t%v\t%v\n",
+					time.Unix(t.At, 0).Format(TimeFormat), t.Direction, t.Kind, t.Amount.String(), t.Counterparty,
+					OpName(t.Op), round, t.Lt)
+			}
+			tw.Flush()
+
+			fmt.Println()
+			fmt.Println("Unreconciled rounds:")
+			tw = tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+			fmt.Fprintln(tw, "ROUND\tRELEASED\tSENT\tREASON")
+			for _, u := range unreconciled {
+				fmt.Fprintf(tw, "%v\t%v\t%v\t%v\n", time.Unix(int64(u.Round), 0).Format(TimeFormat),
+					time.Unix(u.ReleaseAt, 0).Format(TimeFormat), u.Sent, u.Reason)
+			}
+			tw.Flush()
+		}
+	})
+}
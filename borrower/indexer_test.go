@@ -0,0 +1,61 @@
+package borrower
+
+import (
+	"testing"
+
+	"github.com/xssnick/tonutils-go/tlb"
+	"github.com/xssnick/tonutils-go/tvm/cell"
+)
+
+func TestParseResponseBody(t *testing.T) {
+	tests := []struct {
+		name    string
+		body    *cell.Cell
+		op      uint32
+		queryId uint64
+		round   uint32
+	}{
+		{
+			name: "no body",
+		},
+		{
+			name: "comment",
+			body: cell.BeginCell().MustStoreUInt(0, 32).MustStoreStringSnake("paid").EndCell(),
+		},
+		{
+			name: "op only",
+			body: cell.BeginCell().MustStoreUInt(0x1234, 24).EndCell(),
+		},
+		{
+			name: "op and query id",
+			body: cell.BeginCell().MustStoreUInt(LoanRequest, 32).MustStoreUInt(42, 64).EndCell(),
+			op:   LoanRequest, queryId: 42,
+		},
+		{
+			name: "op, query id and round",
+			body: cell.BeginCell().MustStoreUInt(LoanRequest, 32).MustStoreUInt(42, 64).MustStoreUInt(1700000000, 32).
+				EndCell(),
+			op: LoanRequest, queryId: 42, round: 1700000000,
+		},
+		{
+			name: "bounced",
+			body: cell.BeginCell().MustStoreUInt(0xffffffff, 32).MustStoreUInt(LoanRequest, 32).MustStoreUInt(42, 64).
+				MustStoreUInt(1700000000, 32).EndCell(),
+			op: LoanRequest, queryId: 42, round: 1700000000,
+		},
+		{
+			name: "bounced without op",
+			body: cell.BeginCell().MustStoreUInt(0xffffffff, 32).EndCell(),
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			op, queryId, round := parseResponseBody(&tlb.InternalMessage{Body: test.body})
+			if op != test.op || queryId != test.queryId || round != test.round {
+				t.Fatalf("expected op %v, query id %v and round %v, got %v, %v and %v", OpName(test.op), test.queryId,
+					test.round, OpName(op), queryId, round)
+			}
+		})
+	}
+}
@@ -0,0 +1,126 @@
+package borrower
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// InspectEngine prints the config of the validator engine, either as a summary or as JSON.
+func InspectEngine(asJson bool) error {
+	return runCommand(func() {
+		config := loadConfig()
+
+		engine := NewValidatorEngine(config.ValidatorEngine)
+		defer engine.Close()
+
+		engineConfig := engine.GetConfig()
+
+		if asJson {
+			out, err := json.MarshalIndent(engineConfig, "", "  ")
+			if err != nil {
+				panic(fmt.Sprintf("Error in encoding engine config: %v", err))
+			}
+			fmt.Println(string(out))
+			return
+		}
+
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+
+		fmt.Fprintln(w, "Addresses:")
+		fmt.Fprintln(w, "IP\tPORT\tCATEGORIES\tPRIORITY CATEGORIES")
+		for _, a := range engineConfig.Addrs {
+			fmt.Fprintf(w, "%v\t%v\t%v\t%v\n", FormatIp(a.Ip), a.Port, a.Categories, a.PriorityCategories)
+		}
+
+		fmt.Fprintln(w)
+		fmt.Fprintln(w, "ADNL:")
+		fmt.Fprintln(w, "ID\tCATEGORY\tUSAGE")
+		for _, a := range engineConfig.Adnl {
+			fmt.Fprintf(w, "%v\t%v\t%v\n", a.Id.Hex(), a.Category, strings.Join(adnlUsage(engineConfig, a.Id), ", "))
+		}
+
+		fmt.Fprintln(w)
+		fmt.Fprintln(w, "DHT:")
+		for _, d := range engineConfig.Dht {
+			fmt.Fprintf(w, "%v\n", d.Id.Hex())
+		}
+
+		fmt.Fprintln(w)
+		fmt.Fprintln(w, "Validators:")
+		fmt.Fprintln(w, "PERMANENT KEY\tELECTION DATE\tEXPIRE AT\tTEMP KEYS\tADNL ADDRESSES")
+		for _, v := range engineConfig.Validators {
+			tempKeys := []string{}
+			for _, t := range v.TempKeys {
+				tempKeys = append(tempKeys, fmt.Sprintf("%v until %v", t.Key.Hex(), formatUnix(t.ExpireAt)))
+			}
+			adnlAddrs := []string{}
+			for _, a := range v.AdnlAddrs {
+				adnlAddrs = append(adnlAddrs, fmt.Sprintf("%v until %v", a.Id.Hex(), formatUnix(a.ExpireAt)))
+			}
+			fmt.Fprintf(w, "%v\t%v\t%v\t%v\t%v\n", v.Id.Hex(), formatUnix(v.ElectionDate), formatUnix(v.ExpireAt),
+				strings.Join(tempKeys, ", "), strings.Join(adnlAddrs, ", "))
+		}
+
+		fmt.Fprintln(w)
+		fmt.Fprintln(w, "Full node:")
+		fmt.Fprintf(w, "ADNL\t%v\n", engineConfig.FullNode.Hex())
+		for _, s := range engineConfig.FullNodeSlaves {
+			fmt.Fprintf(w, "Slave\t%v:%v\t%v\n", FormatIp(s.Ip), s.Port, s.Adnl.Hex())
+		}
+		for _, m := range engineConfig.FullNodeMasters {
+			fmt.Fprintf(w, "Master\t%v\t%v\n", m.Port, m.Adnl.Hex())
+		}
+		if len(engineConfig.FullNodeConfig) > 0 {
+			fmt.Fprintf(w, "Config\t%s\n", engineConfig.FullNodeConfig)
+		}
+
+		fmt.Fprintln(w)
+		fmt.Fprintln(w, "Liteservers:")
+		fmt.Fprintln(w, "ID\tPORT")
+		for _, l := range engineConfig.LiteServers {
+			fmt.Fprintf(w, "%v\t%v\n", l.Id.Hex(), l.Port)
+		}
+
+		fmt.Fprintln(w)
+		fmt.Fprintln(w, "Control interfaces:")
+		fmt.Fprintln(w, "ID\tPORT\tALLOWED CLIENTS")
+		for _, c := range engineConfig.Control {
+			allowed := []string{}
+			for _, a := range c.Allowed {
+				allowed = append(allowed, fmt.Sprintf("%v (permissions %v)", a.Id.Hex(), a.Permissions))
+			}
+			fmt.Fprintf(w, "%v\t%v\t%v\n", c.Id.Hex(), c.Port, strings.Join(allowed, ", "))
+		}
+
+		w.Flush()
+	})
+}
+
+// adnlUsage describes what an ADNL address of the engine is used for.
+func adnlUsage(config *EngineConfig, id EngineId) []string {
+	usage := []string{}
+	for _, d := range config.Dht {
+		if d.Id == id {
+			usage = append(usage, "dht")
+		}
+	}
+	for _, v := range config.Validators {
+		for _, a := range v.AdnlAddrs {
+			if a.Id == id {
+				usage = append(usage, fmt.Sprintf("validator of %v", formatUnix(v.ElectionDate)))
+			}
+		}
+	}
+	if config.FullNode == id {
+		usage = append(usage, "full node")
+	}
+	return usage
+}
+
+func formatUnix(t uint32) string {
+	return time.Unix(int64(t), 0).Format(TimeFormat)
+}
@@ -0,0 +1,368 @@
+package borrower
+
+import (
+	"context"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/xssnick/tonutils-go/address"
+	"github.com/xssnick/tonutils-go/tlb"
+	"github.com/xssnick/tonutils-go/ton"
+	"github.com/xssnick/tonutils-go/tvm/cell"
+)
+
+type KeeperMessageStatus string
+
+const (
+	KeeperMessagePending   KeeperMessageStatus = "pending"
+	KeeperMessageConfirmed KeeperMessageStatus = "confirmed"
+	KeeperMessageSkipped   KeeperMessageStatus = "skipped"
+)
+
+// KeeperMessage tracks the external messages sent to the treasury to advance a round from one state.
+// Each retry has a new query id, so every attempt has its own body hash.
+type KeeperMessage struct {
+	Round      uint32              `json:"round"`
+	Op         uint32              `json:"op"`
+	State      ParticipationState  `json:"state"`
+	Status     KeeperMessageStatus `json:"status"`
+	Hashes     []string            `json:"hashes"`
+	Attempts   int                 `json:"attempts"`
+	CreatedAt  int64               `json:"created_at"`
+	SentAt     int64               `json:"sent_at"`
+	ResolvedAt int64               `json:"resolved_at,omitempty"`
+	Lt         uint64              `json:"lt,omitempty"`
+	Error      string              `json:"error,omitempty"`
+}
+
+const (
+	keeperRetryMin     = 30 * time.Second
+	keeperRetryMax     = 10 * time.Minute
+	keeperStaleTimeout = 10 * time.Minute
+	keeperHistory      = 30 * 24 * time.Hour
+)
+
+func OpName(op uint32) string {
+	switch op {
+	case ParticipateInElection:
+		return "participate_in_election"
+	case VsetChanged:
+		return "vset_changed"
+	case FinishParticipation:
+		return "finish_participation"
+	case LoanRequest:
+		return "request_loan"
+	}
+	return fmt.Sprintf("0x%08x", op)
+}
+
+type treasuryExternal struct {
+	hash    string
+	op      uint32
+	round   uint32
+	lt      uint64
+	now     uint32
+	success bool
+}
+
+type keeper struct {
+	api             ton.APIClientWrapped
+	ctx             context.Context
+	store           *Store
+	mainchainInfo   *ton.BlockIDExt
+	treasuryAddress *address.Address
+	externals       []treasuryExternal
+	scanned         bool
+}
+
+func newKeeper(api ton.APIClientWrapped, ctx context.Context, store *Store, mainchainInfo *ton.BlockIDExt,
+	treasuryAddress *address.Address) *keeper {
+	return &keeper{
+		api:             api,
+		ctx:             ctx,
+		store:           store,
+		mainchainInfo:   mainchainInfo,
+		treasuryAddress: treasuryAddress,
+	}
+}
+
+// trigger makes sure a message with op is delivered to advance the round out of state, and returns when to check
+// again. Messages are sent again with an exponential backoff only while no transaction of the treasury confirms them.
+func (k *keeper) trigger(op uint32, roundSince uint32, state ParticipationState, label string) time.Duration {
+	formattedRoundSince := time.Unix(int64(roundSince), 0).Format(TimeFormat)
+	now := time.Now()
+	m := k.find(roundSince, op, state)
+
+	if m != nil && m.Status != KeeperMessagePending {
+		if now.Sub(time.Unix(m.ResolvedAt, 0)) < keeperStaleTimeout {
+			return keeperRetryMin
+		}
+		log.Printf("⚠️  Round %v is still %v %v after %v was %v, trying again",
+			formattedRoundSince, state, keeperStaleTimeout, label, m.Status)
+		m = nil
+	}
+
+	if m == nil {
+		m = &KeeperMessage{
+			Round:     roundSince,
+			Op:        op,
+			State:     state,
+			Status:    KeeperMessagePending,
+			CreatedAt: now.Unix(),
+		}
+		k.store.KeeperMessages = append(k.store.KeeperMessages, m)
+	}
+
+	if m.Attempts > 0 {
+		k.check(m)
+		if m.Status == KeeperMessageConfirmed {
+			log.Printf("✅ Confirmed %v for round %v in transaction %v after %v attempts",
+				label, formattedRoundSince, m.Lt, m.Attempts)
+			k.store.save()
+			return keeperRetryMin
+		}
+		if m.Status == KeeperMessageSkipped {
+			log.Printf("⏭  Skipped %v for round %v, another keeper advanced it in transaction %v",
+				label, formattedRoundSince, m.Lt)
+			k.store.save()
+			return keeperRetryMin
+		}
+		due := time.Unix(m.SentAt, 0).Add(keeperBackoff(m.Attempts))
+		if now.Before(due) {
+			return time.Until(due)
+		}
+	} else if other := k.findOther(m); other != nil {
+		m.Status = KeeperMessageSkipped
+		m.ResolvedAt = now.Unix()
+		m.Lt = other.lt
+		log.Printf("⏭  Skipped %v for round %v, another keeper advanced it in transaction %v",
+			label, formattedRoundSince, m.Lt)
+		k.store.save()
+		return keeperRetryMin
+	}
+
+	body := cell.BeginCell().
+		MustStoreUInt(uint64(op), 32).
+		MustStoreUInt(uint64(now.Unix()), 64).
+		MustStoreUInt(uint64(roundSince), 32).
+		EndCell()
+
+	ctx, cancel := context.WithTimeout(k.ctx, 10*time.Second)
+	defer cancel()
+
+	err := k.api.SendExternalMessage(ctx, &tlb.ExternalMessage{
+		DstAddr: k.treasuryAddress,
+		Body:    body,
+	})
+	m.Attempts += 1
+	m.SentAt = now.Unix()
+	if err != nil {
+		m.Error = err.Error()
+		log.Printf("⚠️  Failed to send %v for round %v, attempt %v: %v", label, formattedRoundSince, m.Attempts, err)
+	} else {
+		m.Error = ""
+		m.Hashes = append(m.Hashes, hex.EncodeToString(body.Hash()))
+		log.Printf("☑️  Sent %v for round %v, attempt %v", label, formattedRoundSince, m.Attempts)
+	}
+	k.store.save()
+
+	return keeperBackoff(m.Attempts)
+}
+
+// resolve closes pending messages of a round which is no longer in the state they were sent for.
+func (k *keeper) resolve(roundSince uint32, state ParticipationState) {
+	changed := false
+	for _, m := range k.store.KeeperMessages {
+		if m.Round != roundSince || m.Status != KeeperMessagePending || m.State == state {
+			continue
+		}
+		k.close(m)
+		formattedRoundSince := time.Unix(int64(roundSince), 0).Format(TimeFormat)
+		log.Printf("ℹ️  Round %v moved from %v to %v, %v was %v after %v attempts",
+			formattedRoundSince, m.State, state, OpName(m.Op), m.Status, m.Attempts)
+		changed = true
+	}
+	if changed {
+		k.store.save()
+	}
+}
+
+// prune closes pending messages of rounds that are no longer tracked by the treasury, and forgets old ones.
+func (k *keeper) prune(rounds map[uint32]bool) {
+	cutoff := time.Now().Add(-keeperHistory).Unix()
+	messages := []*KeeperMessage{}
+	changed := false
+	for _, m := range k.store.KeeperMessages {
+		if !rounds[m.Round] && m.Status == KeeperMessagePending {
+			k.close(m)
+			formattedRoundSince := time.Unix(int64(m.Round), 0).Format(TimeFormat)
+			log.Printf("ℹ️  Round %v is finished, %v was %v after %v attempts",
+				formattedRoundSince, OpName(m.Op), m.Status, m.Attempts)
+			changed = true
+		}
+		if rounds[m.Round] || m.CreatedAt > cutoff {
+			messages = append(messages, m)
+		}
+	}
+	if changed || len(messages) != len(k.store.KeeperMessages) {
+		k.store.KeeperMessages = messages
+		k.store.save()
+	}
+}
+
+// close resolves a pending message of a round that has already advanced. When none of our attempts is found,
+// another keeper must have advanced it.
+func (k *keeper) close(m *KeeperMessage) {
+	if m.Attempts > 0 {
+		k.check(m)
+	}
+	if m.Status == KeeperMessagePending {
+		m.Status = KeeperMessageSkipped
+		m.ResolvedAt = time.Now().Unix()
+	}
+}
+
+func (k *keeper) find(roundSince uint32, op uint32, state ParticipationState) *KeeperMessage {
+	for i := len(k.store.KeeperMessages) - 1; i >= 0; i-- {
+		m := k.store.KeeperMessages[i]
+		if m.Round == roundSince && m.Op == op && m.State == state {
+			return m
+		}
+	}
+	return nil
+}
+
+// check looks for the transactions of the treasury which processed one of our attempts.
+// An attempt that aborted in the treasury doesn't confirm the message.
+func (k *keeper) check(m *KeeperMessage) {
+	hashes := map[string]bool{}
+	for _, h := range m.Hashes {
+		hashes[h] = true
+	}
+	for _, e := range k.loadExternals() {
+		if hashes[e.hash] && e.success {
+			m.Status = KeeperMessageConfirmed
+			m.ResolvedAt = time.Now().Unix()
+			m.Lt = e.lt
+			return
+		}
+	}
+	if other := k.findOther(m); other != nil {
+		m.Status = KeeperMessageSkipped
+		m.ResolvedAt = time.Now().Unix()
+		m.Lt = other.lt
+	}
+}
+
+// findOther returns a successful message of another keeper with the same op for the same round,
+// processed after we started tracking it.
+func (k *keeper) findOther(m *KeeperMessage) *treasuryExternal {
+	hashes := map[string]bool{}
+	for _, h := range m.Hashes {
+		hashes[h] = true
+	}
+	externals := k.loadExternals()
+	for i := range externals {
+		e := &externals[i]
+		if e.op == m.Op && e.round == m.Round && e.success && !hashes[e.hash] &&
+			int64(e.now) >= m.CreatedAt-int64(keeperRetryMin.Seconds()) {
+			return e
+		}
+	}
+	return nil
+}
+
+func (k *keeper) loadExternals() []treasuryExternal {
+	if k.scanned {
+		return k.externals
+	}
+	k.scanned = true
+
+	since := time.Now().Unix()
+	for _, m := range k.store.KeeperMessages {
+		if m.Status == KeeperMessagePending && m.CreatedAt < since {
+			since = m.CreatedAt
+		}
+	}
+	since -= int64(keeperStaleTimeout.Seconds())
+
+	ctx, cancel := context.WithTimeout(k.ctx, 30*time.Second)
+	defer cancel()
+
+	account, err := k.api.GetAccount(ctx, k.mainchainInfo, k.treasuryAddress)
+	if err != nil {
+		log.Printf("⚠️  Failed to get treasury account to confirm messages: %v", err)
+		return nil
+	}
+
+	transactions := func() []*tlb.Transaction {
+		defer func() {
+			if err := recover(); err != nil {
+				log.Printf("⚠️  Failed to list treasury transactions to confirm messages: %v", err)
+			}
+		}()
+		return listTransactions(k.api, ctx, account, k.treasuryAddress, 0, func(oldest *tlb.Transaction) bool {
+			return int64(oldest.Now) < since
+		})
+	}()
+	for _, tx := range transactions {
+		if e := loadTreasuryExternal(tx); e != nil {
+			k.externals = append(k.externals, *e)
+		}
+	}
+
+	return k.externals
+}
+
+func loadTreasuryExternal(tx *tlb.Transaction) *treasuryExternal {
+	if tx.IO.In == nil || tx.IO.In.MsgType != tlb.MsgTypeExternalIn {
+		return nil
+	}
+	body := tx.IO.In.Msg.Payload()
+	if body == nil {
+		return nil
+	}
+	s := body.BeginParse()
+	op, err := s.LoadUInt(32)
+	if err != nil {
+		return nil
+	}
+	_, err = s.LoadUInt(64)
+	if err != nil {
+		return nil
+	}
+	round, err := s.LoadUInt(32)
+	if err != nil {
+		return nil
+	}
+	return &treasuryExternal{
+		hash:    hex.EncodeToString(body.Hash()),
+		op:      uint32(op),
+		round:   uint32(round),
+		lt:      tx.LT,
+		now:     tx.Now,
+		success: isTransactionSuccessful(tx),
+	}
+}
+
+func isTransactionSuccessful(tx *tlb.Transaction) bool {
+	description, ok := tx.Description.(tlb.TransactionDescriptionOrdinary)
+	if !ok || description.Aborted {
+		return false
+	}
+	computePhase, ok := description.ComputePhase.Phase.(tlb.ComputePhaseVM)
+	return ok && computePhase.Success
+}
+
+func keeperBackoff(attempts int) time.Duration {
+	backoff := keeperRetryMin
+	for i := 1; i < attempts && backoff < keeperRetryMax; i++ {
+		backoff *= 2
+	}
+	if backoff > keeperRetryMax {
+		backoff = keeperRetryMax
+	}
+	return backoff
+}
@@ -0,0 +1,138 @@
+package borrower
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/xssnick/tonutils-go/address"
+	"github.com/xssnick/tonutils-go/tvm/cell"
+)
+
+type KeyStatus string
+
+const (
+	KeyTracked KeyStatus = "tracked"
+	KeyActive  KeyStatus = "active"
+	KeyExpired KeyStatus = "expired"
+)
+
+// ValidationKey is a validator key of the engine, with whether the treasury still tracks its round.
+type ValidationKey struct {
+	Validator ValidatorConfig
+	Status    KeyStatus
+	State     ParticipationState
+}
+
+// Removable keys are expired longer than the margin and belong to no round tracked by the treasury.
+func (k *ValidationKey) Removable() bool {
+	return k.Status == KeyExpired
+}
+
+func loadValidationKeys(engineConfig *EngineConfig, rounds map[uint32]ParticipationState,
+	margin time.Duration) []*ValidationKey {
+	if margin <= 0 {
+		margin = 24 * time.Hour
+	}
+	now := time.Now()
+	keys := []*ValidationKey{}
+	for _, v := range engineConfig.Validators {
+		k := &ValidationKey{Validator: v, Status: KeyActive}
+		if state, found := rounds[v.ElectionDate]; found {
+			k.Status = KeyTracked
+			k.State = state
+		} else if now.After(time.Unix(int64(v.ExpireAt), 0).Add(margin)) {
+			k.Status = KeyExpired
+		}
+		keys = append(keys, k)
+	}
+	return keys
+}
+
+func loadRoundStates(participations *cell.Dictionary) map[uint32]ParticipationState {
+	rounds := map[uint32]ParticipationState{}
+	if participations != nil {
+		for _, kv := range participations.All() {
+			roundSince := uint32(kv.Key.BeginParse().MustLoadUInt(32))
+			rounds[roundSince] = LoadParticipation(kv.Value).State
+		}
+	}
+	return rounds
+}
+
+// cleanupValidationKeys removes the temp keys, ADNL addresses, and permanent keys of removable keys from the engine.
+func cleanupValidationKeys(engine *Engine, keys []*ValidationKey) (removed []string) {
+	for _, k := range keys {
+		if !k.Removable() {
+			continue
+		}
+		permKeyHash := k.Validator.Id.Hex()
+		if permKeyHash == "" {
+			continue
+		}
+		for _, t := range k.Validator.TempKeys {
+			engine.DelTempKey(permKeyHash, t.Key.Hex())
+		}
+		for _, a := range k.Validator.AdnlAddrs {
+			engine.DelValidatorAddr(permKeyHash, a.Id.Hex())
+		}
+		engine.DelPermKey(permKeyHash)
+		removed = append(removed, fmt.Sprintf("Removed expired key %v of round %v",
+			permKeyHash, formatUnix(k.Validator.ElectionDate)))
+	}
+	return
+}
+
+// Keys prints the validator keys of the engine, and removes expired keys when cleanup is set.
+func Keys(cleanup bool) error {
+	return runCommand(func() {
+		config := loadConfig()
+
+		api, ctx := loadApi(config)
+
+		engine := NewValidatorEngine(config.ValidatorEngine)
+		defer engine.Close()
+
+		treasuryAddress := address.MustParseAddr(config.Treasury)
+
+		mainchainInfo := loadMainchainInfo(api, ctx)
+
+		participations, _ := loadTreasuryState(api, ctx, mainchainInfo, treasuryAddress)
+
+		keys := loadValidationKeys(engine.GetConfig(), loadRoundStates(participations),
+			config.ValidatorEngine.KeyCleanupMargin)
+
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "PERMANENT KEY\tELECTION DATE\tEXPIRE AT\tTEMP KEYS\tADNL ADDRESSES\tSTATUS")
+		for _, k := range keys {
+			status := string(k.Status)
+			if k.Status == KeyTracked {
+				status = fmt.Sprintf("%v (%v)", k.Status, k.State)
+			}
+			fmt.Fprintf(w, "%v\t%v\t%v\t%v\t%v\t%v\n", k.Validator.Id.Hex(), formatUnix(k.Validator.ElectionDate),
+				formatUnix(k.Validator.ExpireAt), len(k.Validator.TempKeys), len(k.Validator.AdnlAddrs), status)
+		}
+		w.Flush()
+
+		if cleanup {
+			for _, r := range cleanupValidationKeys(engine, keys) {
+				fmt.Println(r)
+			}
+		}
+	})
+}
+
+// cleanupKeysIfEnabled removes expired keys when automatic cleanup is configured.
+func cleanupKeysIfEnabled(config *Config, engine *Engine, participations *cell.Dictionary) {
+	if !config.ValidatorEngine.KeyCleanup {
+		return
+	}
+
+	keys := loadValidationKeys(engine.GetConfig(), loadRoundStates(participations),
+		config.ValidatorEngine.KeyCleanupMargin)
+	for _, r := range cleanupValidationKeys(engine, keys) {
+		log.Printf("   🧹 %v", r)
+	}
+}
@@ -0,0 +1,250 @@
+package borrower
+
+import (
+	"encoding/csv"
+	"fmt"
+	"math/big"
+	"os"
+	"strconv"
+	"text/tabwriter"
+	"time"
+
+	"github.com/xssnick/tonutils-go/tlb"
+)
+
+const (
+	// The treasury sorts requests by min payment over loan, where min payment is rounded down to 2^30 nanoTON, about
+	// 1 TON, and loan to 2^40 nanoTON, about 1100 TON.
+	minPaymentRoundingBits = 30
+	loanRoundingBits       = 40
+	// marketAverageRounds is the number of rounds in the moving averages of the market report.
+	marketAverageRounds = 5
+)
+
+// MarketRound is the competition in a round: the lowest rounded RoI that was accepted, the highest reward share
+// accepted at that RoI, and the loans that were requested and given.
+type MarketRound struct {
+	Round            uint32    `json:"round"`
+	Requests         int       `json:"requests"`
+	Accepted         int       `json:"accepted"`
+	Demand           tlb.Coins `json:"demand"`
+	Supply           tlb.Coins `json:"supply"`
+	CutoffRoi        *float64  `json:"cutoff_roi"`
+	CutoffShare      *uint8    `json:"cutoff_share"`
+	CutoffRoiAverage *float64  `json:"cutoff_roi_average"`
+	cutoff           *big.Rat
+}
+
+// MarketTrend is the least squares line of the cut-off RoI and reward share over rounds, with the values it
+// projects for the next round.
+type MarketTrend struct {
+	Rounds          int      `json:"rounds"`
+	RoiSlope        float64  `json:"roi_slope"`
+	NextCutoffRoi   *float64 `json:"next_cutoff_roi"`
+	ShareSlope      float64  `json:"share_slope"`
+	NextCutoffShare *float64 `json:"next_cutoff_share"`
+}
+
+// roundedRoi returns the RoI of a request the way the treasury compares it, as min payment per loan in TON.
+func roundedRoi(minPayment *big.Int, loan *big.Int) *big.Rat {
+	p := new(big.Int).Rsh(minPayment, minPaymentRoundingBits)
+	l := new(big.Int).Rsh(loan, loanRoundingBits)
+	if l.Sign() == 0 {
+		l.SetInt64(1)
+	}
+	p.Lsh(p, minPaymentRoundingBits)
+	l.Lsh(l, loanRoundingBits)
+	return new(big.Rat).SetFrac(p, l)
+}
+
+// loadMarket returns the market of every round of the history that started between from and to, and whose requests
+// are known to be accepted or rejected, which needs a complete round or a snapshot after distribution.
+func loadMarket(history *History, from time.Time, to time.Time) []*MarketRound {
+	market := []*MarketRound{}
+	for _, r := range history.Rounds {
+		since := time.Unix(int64(r.Round), 0)
+		if since.Before(from) || !since.Before(to) || len(r.Requests) == 0 || !r.Complete && !isDistributed(r.State) {
+			continue
+		}
+		m := &MarketRound{Round: r.Round, Requests: len(r.Requests)}
+		demand, supply := big.NewInt(0), big.NewInt(0)
+		for _, q := range r.Requests {
+			demand.Add(demand, q.Loan.Nano())
+			if q.Status != HistoryAccepted {
+				continue
+			}
+			m.Accepted++
+			supply.Add(supply, q.Loan.Nano())
+			roi := roundedRoi(q.MinPayment.Nano(), q.Loan.Nano())
+			if m.cutoff == nil || roi.Cmp(m.cutoff) < 0 {
+				m.cutoff = roi
+				share := q.ValidatorRewardShare
+				m.CutoffShare = &share
+			} else if roi.Cmp(m.cutoff) == 0 && q.ValidatorRewardShare > *m.CutoffShare {
+				share := q.ValidatorRewardShare
+				m.CutoffShare = &share
+			}
+		}
+		m.Demand = tlb.FromNanoTON(demand)
+		m.Supply = tlb.FromNanoTON(supply)
+		if m.cutoff != nil {
+			roi, _ := m.cutoff.Float64()
+			m.CutoffRoi = &roi
+		}
+		market = append(market, m)
+	}
+
+	for i, m := range market {
+		sum, n := 0.0, 0
+		for j := max(0, i-marketAverageRounds+1); j <= i; j++ {
+			if market[j].CutoffRoi != nil {
+				sum += *market[j].CutoffRoi
+				n++
+			}
+		}
+		if n > 0 {
+			average := sum / float64(n)
+			m.CutoffRoiAverage = &average
+		}
+	}
+	return market
+}
+
+// isDistributed is true when a round in state has its loans distributed, from staked on.
+func isDistributed(state string) bool {
+	for s := ParticipationStaked; s <= ParticipationBurning; s++ {
+		if s.String() == state {
+			return true
+		}
+	}
+	return false
+}
+
+// loadMarketTrend fits lines to the cut-off RoI and reward share of the rounds, by round index, and projects them
+// to the round after the last one.
+func loadMarketTrend(market []*MarketRound) *MarketTrend {
+	trend := &MarketTrend{Rounds: len(market)}
+	roiX, roiY, shareX, shareY := []float64{}, []float64{}, []float64{}, []float64{}
+	for i, m := range market {
+		if m.CutoffRoi != nil {
+			roiX = append(roiX, float64(i))
+			roiY = append(roiY, *m.CutoffRoi)
+		}
+		if m.CutoffShare != nil {
+			shareX = append(shareX, float64(i))
+			shareY = append(shareY, float64(*m.CutoffShare))
+		}
+	}
+	next := float64(len(market))
+	if slope, intercept, ok := fitLine(roiX, roiY); ok {
+		trend.RoiSlope = slope
+		roi := max(0, intercept+slope*next)
+		trend.NextCutoffRoi = &roi
+	}
+	if slope, intercept, ok := fitLine(shareX, shareY); ok {
+		trend.ShareSlope = slope
+		share := min(255, max(0, intercept+slope*next))
+		trend.NextCutoffShare = &share
+	}
+	return trend
+}
+
+// fitLine returns the least squares line of the points, which needs at least two distinct x values.
+func fitLine(x []float64, y []float64) (slope float64, intercept float64, ok bool) {
+	n := float64(len(x))
+	if len(x) < 2 {
+		return 0, 0, false
+	}
+	var sx, sy, sxx, sxy float64
+	for i := range x {
+		sx += x[i]
+		sy += y[i]
+		sxx += x[i] * x[i]
+		sxy += x[i] * y[i]
+	}
+	d := n*sxx - sx*sx
+	if d == 0 {
+		return 0, 0, false
+	}
+	slope = (n*sxy - sx*sy) / d
+	intercept = (sy - slope*sx) / n
+	return slope, intercept, true
+}
+
+func formatRoi(roi *float64) string {
+	if roi == nil {
+		return ""
+	}
+	return strconv.FormatFloat(*roi*100, 'f', 6, 64) + "%"
+}
+
+func formatShare(share *uint8) string {
+	if share == nil {
+		return ""
+	}
+	return fmt.Sprintf("%v (%.1f%%)", *share, float64(*share)/255*100)
+}
+
+// ReportMarket prints the market of the indexed rounds between from and to, as a table, CSV, or JSON.
+func ReportMarket(from string, to string, format string) error {
+	return runCommand(func() {
+		config := loadConfig()
+
+		fromTime, toTime := parseReportRange(from, to)
+
+		history := loadHistory(config)
+		if len(history.Rounds) == 0 {
+			panic("Error, the history is empty, run borrower index first")
+		}
+
+		market := loadMarket(history, fromTime, toTime)
+		trend := loadMarketTrend(market)
+
+		switch format {
+		case "json":
+			printJson(map[string]any{"rounds": market, "trend": trend})
+		case "csv":
+			w := csv.NewWriter(os.Stdout)
+			w.Write([]string{"round", "requests", "accepted", "demand", "supply", "cutoff_roi", "cutoff_share",
+				"cutoff_roi_average"})
+			for _, m := range market {
+				share := ""
+				if m.CutoffShare != nil {
+					share = strconv.Itoa(int(*m.CutoffShare))
+				}
+				w.Write([]string{strconv.FormatUint(uint64(m.Round), 10), strconv.Itoa(m.Requests),
+					strconv.Itoa(m.Accepted), m.Demand.String(), m.Supply.String(), formatFloat(m.CutoffRoi), share,
+					formatFloat(m.CutoffRoiAverage)})
+			}
+			w.Flush()
+		default:
+			tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+			fmt.Fprintln(tw, "ROUND\tREQUESTS\tACCEPTED\tDEMAND\tSUPPLY\tCUT-OFF ROI\tCUT-OFF SHARE\t"+
+				fmt.Sprintf("ROI AVERAGE OF %v", marketAverageRounds))
+			for _, m := range market {
+				fmt.Fprintf(tw, "%v\t%v\t%v\t%v\t%v\t%v\t%v\t%v\n",
+					time.Unix(int64(m.Round), 0).Format(TimeFormat), m.Requests, m.Accepted, m.Demand.String(),
+					m.Supply.String(), formatRoi(m.CutoffRoi), formatShare(m.CutoffShare),
+					formatRoi(m.CutoffRoiAverage))
+			}
+			tw.Flush()
+
+			fmt.Println()
+			tw = tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+			fmt.Fprintf(tw, "Cut-off RoI trend:\t%v per round\n", formatRoi(&trend.RoiSlope))
+			fmt.Fprintf(tw, "Next cut-off RoI:\t%v\n", formatRoi(trend.NextCutoffRoi))
+			fmt.Fprintf(tw, "Cut-off share trend:\t%.2f per round\n", trend.ShareSlope)
+			if trend.NextCutoffShare != nil {
+				fmt.Fprintf(tw, "Next cut-off share:\t%.0f\n", *trend.NextCutoffShare)
+			}
+			tw.Flush()
+		}
+	})
+}
+
+func formatFloat(f *float64) string {
+	if f == nil {
+		return ""
+	}
+	return strconv.FormatFloat(*f, 'g', -1, 64)
+}
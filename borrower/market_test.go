@@ -0,0 +1,154 @@
+package borrower
+
+import (
+	"math"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/xssnick/tonutils-go/tlb"
+)
+
+func TestRoundedRoi(t *testing.T) {
+	tests := []struct {
+		name       string
+		minPayment *big.Int
+		loan       *big.Int
+		want       *big.Rat
+	}{
+		{
+			name:       "exact",
+			minPayment: big.NewInt(3 << 30),
+			loan:       big.NewInt(4 << 40),
+			want:       big.NewRat(3, 4<<10),
+		},
+		{
+			name:       "rounded down",
+			minPayment: big.NewInt(3<<30 + 1<<29),
+			loan:       big.NewInt(4<<40 + 1<<39),
+			want:       big.NewRat(3, 4<<10),
+		},
+		{
+			name:       "min payment rounds to 0",
+			minPayment: big.NewInt(1<<30 - 1),
+			loan:       big.NewInt(4 << 40),
+			want:       new(big.Rat),
+		},
+		{
+			name:       "loan rounds to 0",
+			minPayment: big.NewInt(3 << 30),
+			loan:       big.NewInt(1<<40 - 1),
+			want:       big.NewRat(3, 1<<10),
+		},
+		{
+			name:       "no loan",
+			minPayment: big.NewInt(3 << 30),
+			loan:       big.NewInt(0),
+			want:       big.NewRat(3, 1<<10),
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			roi := roundedRoi(test.minPayment, test.loan)
+			if roi.Cmp(test.want) != 0 {
+				t.Fatalf("expected %v, got %v", test.want, roi)
+			}
+		})
+	}
+}
+
+func TestFitLine(t *testing.T) {
+	tests := []struct {
+		name      string
+		x         []float64
+		y         []float64
+		slope     float64
+		intercept float64
+		ok        bool
+	}{
+		{
+			name: "no points",
+		},
+		{
+			name: "one point",
+			x:    []float64{1},
+			y:    []float64{2},
+		},
+		{
+			name: "same x",
+			x:    []float64{1, 1, 1},
+			y:    []float64{1, 2, 3},
+		},
+		{
+			name: "line",
+			x:    []float64{0, 1, 2, 3},
+			y:    []float64{1, 3, 5, 7},
+			ok:   true, slope: 2, intercept: 1,
+		},
+		{
+			name: "flat",
+			x:    []float64{0, 2},
+			y:    []float64{4, 4},
+			ok:   true, slope: 0, intercept: 4,
+		},
+		{
+			name: "least squares",
+			x:    []float64{0, 1, 2},
+			y:    []float64{0, 2, 1},
+			ok:   true, slope: 0.5, intercept: 0.5,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			slope, intercept, ok := fitLine(test.x, test.y)
+			if ok != test.ok || math.Abs(slope-test.slope) > 1e-9 || math.Abs(intercept-test.intercept) > 1e-9 {
+				t.Fatalf("expected %v with slope %v and intercept %v, got %v with %v and %v", test.ok, test.slope,
+					test.intercept, ok, slope, intercept)
+			}
+		})
+	}
+}
+
+func TestLoadMarket(t *testing.T) {
+	request := func(validator string, minPayment string, share uint8, status HistoryRequestStatus) *HistoryRequest {
+		return &HistoryRequest{
+			Validator:            validator,
+			Loan:                 tlb.MustFromTON("1100"),
+			MinPayment:           tlb.MustFromTON(minPayment),
+			ValidatorRewardShare: share,
+			Status:               status,
+		}
+	}
+	history := &History{Rounds: []*HistoryRound{
+		{Round: 100, State: ParticipationDistributing.String(), SnapshotAt: 150,
+			Requests: []*HistoryRequest{request("a", "2", 10, HistoryAccepted)}},
+		{Round: 200, State: ParticipationStaked.String(), SnapshotAt: 250, Requests: []*HistoryRequest{
+			request("a", "3", 10, HistoryAccepted),
+			request("b", "2", 20, HistoryAccepted),
+			request("c", "2", 30, HistoryAccepted),
+			request("d", "1", 40, HistoryUnknown),
+		}},
+		{Round: 300, State: ParticipationOpen.String(), SnapshotAt: 350, Complete: true,
+			Requests: []*HistoryRequest{request("a", "2", 10, HistoryRejected)}},
+	}}
+
+	market := loadMarket(history, time.Unix(0, 0), time.Unix(1000, 0))
+	if len(market) != 2 || market[0].Round != 200 || market[1].Round != 300 {
+		t.Fatalf("expected rounds 200 and 300, got %v rounds", len(market))
+	}
+	m := market[0]
+	if m.Requests != 4 || m.Accepted != 3 || m.Demand.String() != "4400" || m.Supply.String() != "3300" {
+		t.Fatalf("expected 3 of 4 requests accepted, got %v of %v, demand %v and supply %v", m.Accepted, m.Requests,
+			m.Demand.String(), m.Supply.String())
+	}
+	if m.CutoffRoi == nil || *m.CutoffRoi != 1.0/1024 || *m.CutoffShare != 30 {
+		t.Fatalf("expected cut-off RoI %v with share 30, got %v with %v", 1.0/1024, formatRoi(m.CutoffRoi),
+			formatShare(m.CutoffShare))
+	}
+	if market[1].CutoffRoi != nil || *market[1].CutoffRoiAverage != *m.CutoffRoi {
+		t.Fatalf("expected no cut-off RoI with the average of the earlier round, got %v",
+			formatRoi(market[1].CutoffRoi))
+	}
+}
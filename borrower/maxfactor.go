@@ -0,0 +1,245 @@
+package borrower
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"math"
+	"math/big"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/xssnick/tonutils-go/tlb"
+	"github.com/xssnick/tonutils-go/ton"
+)
+
+// ElectionLimits is the network config that decides which stakes are elected and how much of them is used.
+type ElectionLimits struct {
+	Stake         *StakeConfig
+	MinValidators uint16
+	MaxValidators uint16
+}
+
+// MaxFactorAdvice is how the elector is expected to treat our stake in the next election, if the other validators
+// stake like they did in the latest one. Required is the max factor that keeps our stake from being clipped, which
+// may be above the network max, and Recommended is the lowest max_factor_ratio that reaches it, within the network
+// max.
+type MaxFactorAdvice struct {
+	Stake           *big.Int
+	Configured      uint32
+	NetworkMax      uint32
+	Validators      int
+	MinElectedStake *big.Int
+	Elected         bool
+	EffectiveStake  *big.Int
+	Clipped         bool
+	Required        uint32
+	Recommended     float64
+}
+
+// electionCandidate is a stake in a simulated election.
+type electionCandidate struct {
+	stake     *big.Int
+	maxFactor uint32
+	ours      bool
+	trueStake *big.Int
+}
+
+func loadElectionLimits(api ton.APIClientWrapped, ctx context.Context,
+	mainchainInfo *ton.BlockIDExt) *ElectionLimits {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	blockchainConfig, err := api.GetBlockchainConfig(ctx, mainchainInfo, ConfigValidators, ConfigStake)
+	if err != nil {
+		panic(fmt.Sprintf("Error in getting blockchain config: %v", err))
+	}
+
+	maxValidators, _, minValidators := GetValidatorCounts(blockchainConfig.Get(ConfigValidators))
+	return &ElectionLimits{
+		Stake:         GetStakeConfig(blockchainConfig.Get(ConfigStake)),
+		MinValidators: minValidators,
+		MaxValidators: maxValidators,
+	}
+}
+
+// simulateElection elects the candidates the way the elector does. Stakes are capped at max_stake, those below
+// min_stake are dropped, and max factors are capped at max_stake_factor. Then for every number of validators, the
+// lowest elected stake times the max factor of each validator clips its stake, and the number with the highest total
+// stake wins. It returns the lowest elected stake and the elected candidates with their true stakes, or nil when
+// there is no valid election.
+func simulateElection(candidates []*electionCandidate, limits *ElectionLimits) (*big.Int, []*electionCandidate) {
+	valid := []*electionCandidate{}
+	for _, c := range candidates {
+		stake := c.stake
+		if limits.Stake.MaxStake.Sign() > 0 && stake.Cmp(limits.Stake.MaxStake) > 0 {
+			stake = limits.Stake.MaxStake
+		}
+		if stake.Cmp(limits.Stake.MinStake) < 0 {
+			continue
+		}
+		valid = append(valid, &electionCandidate{
+			stake:     stake,
+			maxFactor: min(c.maxFactor, limits.Stake.MaxStakeFactor),
+			ours:      c.ours,
+		})
+	}
+	sort.SliceStable(valid, func(i, j int) bool {
+		return valid[i].stake.Cmp(valid[j].stake) > 0
+	})
+
+	n := min(len(valid), int(limits.MaxValidators))
+	if n < int(limits.MinValidators) || n == 0 {
+		return nil, nil
+	}
+	var best *big.Int
+	bestCount := 0
+	for i := max(int(limits.MinValidators), 1); i <= n; i++ {
+		m := valid[i-1].stake
+		total := big.NewInt(0)
+		for _, c := range valid[:i] {
+			total.Add(total, clipStake(c.stake, m, c.maxFactor))
+		}
+		if best == nil || total.Cmp(best) > 0 {
+			best = total
+			bestCount = i
+		}
+	}
+	if best.Cmp(limits.Stake.MinTotalStake) < 0 {
+		return nil, nil
+	}
+
+	m := valid[bestCount-1].stake
+	elected := valid[:bestCount]
+	for _, c := range elected {
+		c.trueStake = clipStake(c.stake, m, c.maxFactor)
+	}
+	return m, elected
+}
+
+// clipStake returns the part of a stake that the elector uses, at most the lowest elected stake times the max factor.
+func clipStake(stake *big.Int, minElected *big.Int, maxFactor uint32) *big.Int {
+	limit := new(big.Int).Mul(minElected, big.NewInt(int64(maxFactor)))
+	limit.Rsh(limit, 16)
+	if stake.Cmp(limit) > 0 {
+		return limit
+	}
+	return new(big.Int).Set(stake)
+}
+
+// adviseMaxFactor elects our stake with the stakes of the latest election, whose max factors are unknown and assumed
+// to be the network max. The election with our max factor at the network max gives the lowest elected stake that
+// our stake is compared to, and the election with the configured max factor gives our effective stake.
+func adviseMaxFactor(stakes []*big.Int, stake *big.Int, configured uint32, limits *ElectionLimits) *MaxFactorAdvice {
+	a := &MaxFactorAdvice{
+		Stake:          stake,
+		Configured:     configured,
+		NetworkMax:     limits.Stake.MaxStakeFactor,
+		EffectiveStake: big.NewInt(0),
+		Required:       1 << 16,
+		Recommended:    1,
+	}
+
+	elect := func(maxFactor uint32) (*big.Int, []*electionCandidate) {
+		candidates := []*electionCandidate{{stake: stake, maxFactor: maxFactor, ours: true}}
+		for _, s := range stakes {
+			candidates = append(candidates, &electionCandidate{stake: s, maxFactor: limits.Stake.MaxStakeFactor})
+		}
+		return simulateElection(candidates, limits)
+	}
+
+	m, elected := elect(limits.Stake.MaxStakeFactor)
+	if m == nil {
+		return a
+	}
+	a.MinElectedStake = m
+	a.Validators = len(elected)
+	if !hasOurs(elected) {
+		return a
+	}
+	required := new(big.Int).Lsh(stake, 16)
+	required.Add(required, new(big.Int).Sub(m, big.NewInt(1)))
+	required.Quo(required, m)
+	if required.Cmp(big.NewInt(math.MaxUint32)) > 0 {
+		a.Required = math.MaxUint32
+	} else {
+		a.Required = max(uint32(required.Uint64()), 1<<16)
+	}
+	if a.Required > a.NetworkMax {
+		a.Recommended = float64(a.NetworkMax) / 65536
+	} else {
+		a.Recommended = min(math.Ceil(float64(a.Required)/65536*100)/100, float64(a.NetworkMax)/65536)
+	}
+
+	m, elected = elect(configured)
+	if m == nil {
+		return a
+	}
+	a.MinElectedStake = m
+	a.Validators = len(elected)
+	for _, c := range elected {
+		if c.ours {
+			a.Elected = true
+			a.EffectiveStake = c.trueStake
+			a.Clipped = c.trueStake.Cmp(c.stake) < 0 || c.stake.Cmp(stake) < 0
+		}
+	}
+	return a
+}
+
+func hasOurs(candidates []*electionCandidate) bool {
+	for _, c := range candidates {
+		if c.ours {
+			return true
+		}
+	}
+	return false
+}
+
+// checkMaxFactor warns when the configured max factor is above the max stake factor of the network, since the
+// elector uses the network max instead.
+func checkMaxFactor(maxFactor uint32, limits *ElectionLimits) (CheckStatus, string) {
+	networkMax := limits.Stake.MaxStakeFactor
+	if maxFactor > networkMax {
+		return CheckWarn, fmt.Sprintf("max_factor_ratio %v is above max_stake_factor %v of the network, which the "+
+			"elector uses instead", formatFactor(maxFactor), formatFactor(networkMax))
+	}
+	return CheckPass, fmt.Sprintf("max_factor_ratio %v is within max_stake_factor %v of the network",
+		formatFactor(maxFactor), formatFactor(networkMax))
+}
+
+// warnMaxFactor logs a warning when the configured max factor is above the max stake factor of the network.
+func warnMaxFactor(api ton.APIClientWrapped, ctx context.Context, mainchainInfo *ton.BlockIDExt, maxFactor uint32) {
+	if status, detail := checkMaxFactor(maxFactor, loadElectionLimits(api, ctx, mainchainInfo)); status == CheckWarn {
+		log.Printf("   ⚠️  %v", detail)
+	}
+}
+
+func formatFactor(factor uint32) string {
+	return strconv.FormatFloat(float64(factor)/65536, 'f', -1, 64)
+}
+
+func printMaxFactorAdvice(a *MaxFactorAdvice) {
+	fmt.Printf("Network max stake factor: %v\n", formatFactor(a.NetworkMax))
+	if a.MinElectedStake == nil {
+		fmt.Println("⚠️  The stakes of the latest election don't make a valid election with your stake")
+		return
+	}
+	fmt.Printf("Expected election: %v validators, lowest stake %v TON\n", a.Validators,
+		tlb.FromNanoTON(a.MinElectedStake).String())
+	if !a.Elected {
+		fmt.Printf("⚠️  A stake of %v TON is not expected to be elected\n", tlb.FromNanoTON(a.Stake).String())
+		return
+	}
+	if a.Clipped {
+		fmt.Printf("⚠️  Your stake of %v TON is expected to be clipped to %v TON at max_factor_ratio %v\n",
+			tlb.FromNanoTON(a.Stake).String(), tlb.FromNanoTON(a.EffectiveStake).String(),
+			formatFactor(a.Configured))
+	}
+	if a.Required > a.NetworkMax {
+		fmt.Printf("⚠️  Your stake needs a max factor of %v, which is above the network max, so lower the loan to "+
+			"avoid clipping\n", formatFactor(a.Required))
+	}
+	fmt.Printf("Recommended max_factor_ratio: %v\n", strconv.FormatFloat(a.Recommended, 'f', -1, 64))
+}
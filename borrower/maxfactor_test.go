@@ -0,0 +1,244 @@
+package borrower
+
+import (
+	"math"
+	"math/big"
+	"testing"
+
+	"github.com/xssnick/tonutils-go/tlb"
+)
+
+func nanoTon(amount string) *big.Int {
+	return tlb.MustFromTON(amount).Nano()
+}
+
+func testLimits(minValidators uint16, maxValidators uint16) *ElectionLimits {
+	return &ElectionLimits{
+		Stake: &StakeConfig{
+			MinStake:       nanoTon("10000"),
+			MaxStake:       nanoTon("10000000"),
+			MinTotalStake:  nanoTon("100000"),
+			MaxStakeFactor: 3 << 16,
+		},
+		MinValidators: minValidators,
+		MaxValidators: maxValidators,
+	}
+}
+
+func TestSimulateElection(t *testing.T) {
+	candidate := func(stake string, maxFactor uint32) *electionCandidate {
+		return &electionCandidate{stake: nanoTon(stake), maxFactor: maxFactor}
+	}
+
+	tests := []struct {
+		name       string
+		candidates []*electionCandidate
+		limits     *ElectionLimits
+		minElected string
+		trueStakes []string
+	}{
+		{
+			name: "equal stakes",
+			candidates: []*electionCandidate{
+				candidate("100000", 3<<16), candidate("100000", 3<<16), candidate("100000", 3<<16),
+			},
+			limits:     testLimits(1, 10),
+			minElected: "100000",
+			trueStakes: []string{"100000", "100000", "100000"},
+		},
+		{
+			name: "all elected",
+			candidates: []*electionCandidate{
+				candidate("1000000", 3<<16), candidate("600000", 3<<16), candidate("600000", 1<<16),
+				candidate("600000", 3<<16),
+			},
+			limits:     testLimits(1, 10),
+			minElected: "600000",
+			trueStakes: []string{"1000000", "600000", "600000", "600000"},
+		},
+		{
+			name: "clipped by own max factor",
+			candidates: []*electionCandidate{
+				candidate("1000000", 3<<16/2), candidate("600000", 3<<16),
+			},
+			limits:     testLimits(1, 10),
+			minElected: "600000",
+			trueStakes: []string{"900000", "600000"},
+		},
+		{
+			name: "small stake left out",
+			candidates: []*electionCandidate{
+				candidate("1000000", 3<<16), candidate("1000000", 3<<16), candidate("20000", 3<<16),
+			},
+			limits:     testLimits(1, 10),
+			minElected: "1000000",
+			trueStakes: []string{"1000000", "1000000"},
+		},
+		{
+			name: "max factor capped at the network max",
+			candidates: []*electionCandidate{
+				candidate("1000000", 10<<16), candidate("200000", 3<<16),
+			},
+			limits:     testLimits(2, 10),
+			minElected: "200000",
+			trueStakes: []string{"600000", "200000"},
+		},
+		{
+			name: "max validators",
+			candidates: []*electionCandidate{
+				candidate("300000", 3<<16), candidate("200000", 3<<16), candidate("100000", 3<<16),
+			},
+			limits:     testLimits(1, 2),
+			minElected: "200000",
+			trueStakes: []string{"300000", "200000"},
+		},
+		{
+			name: "below min stake",
+			candidates: []*electionCandidate{
+				candidate("200000", 3<<16), candidate("9999", 3<<16),
+			},
+			limits: testLimits(2, 10),
+		},
+		{
+			name: "below min total stake",
+			candidates: []*electionCandidate{
+				candidate("40000", 3<<16), candidate("40000", 3<<16),
+			},
+			limits: testLimits(1, 10),
+		},
+		{
+			name:   "no candidates",
+			limits: testLimits(1, 10),
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			m, elected := simulateElection(test.candidates, test.limits)
+			if test.minElected == "" {
+				if m != nil || elected != nil {
+					t.Fatalf("expected no election, got %v validators", len(elected))
+				}
+				return
+			}
+			if m == nil || m.Cmp(nanoTon(test.minElected)) != 0 {
+				t.Fatalf("expected lowest elected stake %v, got %v", test.minElected, m)
+			}
+			if len(elected) != len(test.trueStakes) {
+				t.Fatalf("expected %v validators, got %v", len(test.trueStakes), len(elected))
+			}
+			for i, s := range test.trueStakes {
+				if elected[i].trueStake.Cmp(nanoTon(s)) != 0 {
+					t.Fatalf("expected true stake %v of validator %v, got %v", s, i,
+						tlb.FromNanoTON(elected[i].trueStake).String())
+				}
+			}
+		})
+	}
+}
+
+func TestAdviseMaxFactor(t *testing.T) {
+	stakes := func(amounts ...string) []*big.Int {
+		s := []*big.Int{}
+		for _, a := range amounts {
+			s = append(s, nanoTon(a))
+		}
+		return s
+	}
+
+	tests := []struct {
+		name           string
+		stakes         []*big.Int
+		stake          string
+		configured     uint32
+		limits         *ElectionLimits
+		elected        bool
+		effectiveStake string
+		clipped        bool
+		required       uint32
+		recommended    float64
+	}{
+		{
+			name:           "not clipped",
+			stakes:         stakes("600000", "600000"),
+			stake:          "600000",
+			configured:     1 << 16,
+			limits:         testLimits(1, 10),
+			elected:        true,
+			effectiveStake: "600000",
+			required:       1 << 16,
+			recommended:    1,
+		},
+		{
+			name:           "clipped",
+			stakes:         stakes("600000", "600000"),
+			stake:          "1000000",
+			configured:     3 << 16 / 2,
+			limits:         testLimits(1, 10),
+			elected:        true,
+			effectiveStake: "900000",
+			clipped:        true,
+			required:       109227,
+			recommended:    1.67,
+		},
+		{
+			name:           "above the network max",
+			stakes:         stakes("200000", "200000"),
+			stake:          "1000000",
+			configured:     3 << 16,
+			limits:         testLimits(3, 10),
+			elected:        true,
+			effectiveStake: "600000",
+			clipped:        true,
+			required:       5 << 16,
+			recommended:    3,
+		},
+		{
+			name:           "above MaxUint32",
+			stakes:         stakes("50000", "50000"),
+			stake:          "10000000000",
+			configured:     3 << 16,
+			limits:         testLimits(3, 10),
+			elected:        true,
+			effectiveStake: "150000",
+			clipped:        true,
+			required:       math.MaxUint32,
+			recommended:    3,
+		},
+		{
+			name:           "not elected",
+			stakes:         stakes("600000", "600000"),
+			stake:          "10000",
+			configured:     3 << 16,
+			limits:         testLimits(1, 2),
+			effectiveStake: "0",
+			required:       1 << 16,
+			recommended:    1,
+		},
+		{
+			name:           "no election",
+			stakes:         stakes(),
+			stake:          "50000",
+			configured:     3 << 16,
+			limits:         testLimits(1, 10),
+			effectiveStake: "0",
+			required:       1 << 16,
+			recommended:    1,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			a := adviseMaxFactor(test.stakes, nanoTon(test.stake), test.configured, test.limits)
+			if a.Elected != test.elected || a.EffectiveStake.Cmp(nanoTon(test.effectiveStake)) != 0 ||
+				a.Clipped != test.clipped {
+				t.Fatalf("expected elected %v with %v TON, clipped %v, got %v with %v TON, clipped %v", test.elected,
+					test.effectiveStake, test.clipped, a.Elected, tlb.FromNanoTON(a.EffectiveStake).String(), a.Clipped)
+			}
+			if a.Required != test.required || a.Recommended != test.recommended {
+				t.Fatalf("expected required %v and recommended %v, got %v and %v", test.required,
+					test.recommended, a.Required, a.Recommended)
+			}
+		})
+	}
+}
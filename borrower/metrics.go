@@ -0,0 +1,130 @@
+package borrower
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+type metric struct {
+	help   string
+	series map[string]*series
+}
+
+type series struct {
+	labels map[string]string
+	value  float64
+	// since makes the value the seconds elapsed since this unix time at the time of scraping
+	since int64
+}
+
+var metrics = struct {
+	sync.Mutex
+	all map[string]*metric
+}{all: map[string]*metric{}}
+
+func setGauge(name string, help string, labels map[string]string, value float64) {
+	putSeries(name, help, &series{labels: labels, value: value})
+}
+
+// setElapsedGauge exposes the seconds elapsed since a unix time, which stays correct between updates.
+func setElapsedGauge(name string, help string, labels map[string]string, since int64) {
+	putSeries(name, help, &series{labels: labels, since: since})
+}
+
+// resetGauge removes all series of a metric, so that series of gone rounds are not exposed anymore.
+func resetGauge(name string) {
+	metrics.Lock()
+	defer metrics.Unlock()
+	delete(metrics.all, name)
+}
+
+func putSeries(name string, help string, s *series) {
+	metrics.Lock()
+	defer metrics.Unlock()
+	m := metrics.all[name]
+	if m == nil {
+		m = &metric{help: help, series: map[string]*series{}}
+		metrics.all[name] = m
+	}
+	m.series[formatLabels(s.labels)] = s
+}
+
+func formatLabels(labels map[string]string) string {
+	if len(labels) == 0 {
+		return ""
+	}
+	keys := make([]string, 0, len(labels))
+	for k := range labels {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	pairs := make([]string, 0, len(keys))
+	for _, k := range keys {
+		v := strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(labels[k])
+		pairs = append(pairs, fmt.Sprintf(`%s="%s"`, k, v))
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+func writeMetrics(w http.ResponseWriter, _ *http.Request) {
+	metrics.Lock()
+	defer metrics.Unlock()
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	names := make([]string, 0, len(metrics.all))
+	for name := range metrics.all {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	now := time.Now().Unix()
+	for _, name := range names {
+		m := metrics.all[name]
+		fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s gauge\n", name, m.help, name)
+		keys := make([]string, 0, len(m.series))
+		for k := range m.series {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			s := m.series[k]
+			value := s.value
+			if s.since != 0 {
+				value = float64(now - s.since)
+			}
+			fmt.Fprintf(w, "%s%s %v\n", name, k, value)
+		}
+	}
+}
+
+// ServeMetrics serves the metrics in Prometheus text format until stopped. It returns immediately when no address
+// is configured for metrics.
+func ServeMetrics(stop <-chan struct{}) {
+	config, err := ReadConfig()
+	if err != nil || config.Metrics == "" {
+		return
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/metrics", writeMetrics)
+	server := &http.Server{Addr: config.Metrics, Handler: mux}
+
+	go func() {
+		<-stop
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		server.Shutdown(ctx)
+	}()
+
+	log.Printf("📈 Serving metrics on %v", config.Metrics)
+	err = server.ListenAndServe()
+	if err != nil && !errors.Is(err, http.ErrServerClosed) {
+		log.Printf("❌ Error in serving metrics: %v", err)
+	}
+}
@@ -0,0 +1,282 @@
+package borrower
+
+import (
+	"bytes"
+	"context"
+	"log"
+	"time"
+
+	"github.com/xssnick/tonutils-go/address"
+	"github.com/xssnick/tonutils-go/tlb"
+	"github.com/xssnick/tonutils-go/ton"
+)
+
+type Event uint8
+
+const (
+	EventProcess Event = iota
+	EventRequest
+)
+
+type monitor struct {
+	api             ton.APIClientWrapped
+	ctx             context.Context
+	treasuryAddress *address.Address
+	events          chan<- Event
+	vsetHash        []byte
+	states          map[uint32]ParticipationState
+	deadlines       map[uint32]bool
+}
+
+// Monitor follows new masterchain blocks and the transactions of the treasury, and sends an event as soon as
+// participations need to be processed or a loan needs to be requested. It returns immediately when monitoring is
+// not enabled, and the timers of the main loop remain as a safety net in any case.
+func Monitor(stop <-chan struct{}, events chan<- Event) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		<-stop
+		cancel()
+	}()
+
+	var m *monitor
+	for m == nil {
+		m = newMonitor(ctx, events)
+		if m == nil {
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(1 * time.Minute):
+			}
+		} else if m.api == nil {
+			return
+		}
+	}
+
+	log.Printf("👀 Monitoring new blocks and treasury transactions")
+
+	blocks := make(chan *ton.BlockIDExt)
+	go m.followBlocks(ctx, blocks)
+
+	transactions := make(chan *tlb.Transaction)
+	lastLt := m.loadLastLt()
+	go m.api.SubscribeOnTransactions(ctx, m.treasuryAddress, lastLt, transactions)
+
+	m.safely(m.refreshStates)
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+
+		case block, ok := <-blocks:
+			if !ok {
+				return
+			}
+			m.safely(func() { m.onBlock(block) })
+
+		case tx, ok := <-transactions:
+			if !ok {
+				log.Printf("⚠️  Stopped monitoring treasury transactions")
+				transactions = nil
+				continue
+			}
+			m.safely(func() { m.onTransaction(tx) })
+		}
+	}
+}
+
+func newMonitor(ctx context.Context, events chan<- Event) (m *monitor) {
+	defer func() {
+		if err := recover(); err != nil {
+			m = nil
+			log.Printf("❌ %s", err)
+		}
+	}()
+
+	config := loadConfig()
+	if !config.Monitor {
+		return &monitor{}
+	}
+
+	api, _ := loadApi(config)
+
+	return &monitor{
+		api:             api,
+		ctx:             ctx,
+		treasuryAddress: address.MustParseAddr(config.Treasury),
+		events:          events,
+		deadlines:       map[uint32]bool{},
+	}
+}
+
+func (m *monitor) safely(f func()) {
+	defer func() {
+		if err := recover(); err != nil {
+			log.Printf("❌ %s", err)
+		}
+	}()
+	f()
+}
+
+func (m *monitor) emit(event Event) {
+	select {
+	case m.events <- event:
+	default:
+	}
+}
+
+func (m *monitor) loadLastLt() uint64 {
+	ctx, cancel := context.WithTimeout(m.ctx, 10*time.Second)
+	defer cancel()
+
+	mainchainInfo, err := m.api.CurrentMasterchainInfo(ctx)
+	if err != nil {
+		return 0
+	}
+	account, err := m.api.GetAccount(ctx, mainchainInfo, m.treasuryAddress)
+	if err != nil {
+		return 0
+	}
+	return account.LastTxLT
+}
+
+// followBlocks sends every new masterchain block, and skips to the latest block when falling behind.
+func (m *monitor) followBlocks(ctx context.Context, blocks chan<- *ton.BlockIDExt) {
+	defer close(blocks)
+
+	var master *ton.BlockIDExt
+	for ctx.Err() == nil {
+		if master == nil {
+			c, cancel := context.WithTimeout(ctx, 10*time.Second)
+			current, err := m.api.CurrentMasterchainInfo(c)
+			cancel()
+			if err != nil {
+				if !sleep(ctx, 3*time.Second) {
+					return
+				}
+				continue
+			}
+			master = current
+		}
+
+		c, cancel := context.WithTimeout(ctx, 30*time.Second)
+		next, err := m.api.WaitForBlock(master.SeqNo+1).LookupBlock(c, master.Workchain, master.Shard, master.SeqNo+1)
+		cancel()
+		if err != nil {
+			master = nil
+			if !sleep(ctx, 3*time.Second) {
+				return
+			}
+			continue
+		}
+		master = next
+
+		select {
+		case <-ctx.Done():
+			return
+		case blocks <- next:
+		}
+	}
+}
+
+// sleep waits for d, and returns false when ctx is done first.
+func sleep(ctx context.Context, d time.Duration) bool {
+	select {
+	case <-ctx.Done():
+		return false
+	case <-time.After(d):
+		return true
+	}
+}
+
+func (m *monitor) onBlock(block *ton.BlockIDExt) {
+	ctx, cancel := context.WithTimeout(m.ctx, 10*time.Second)
+	defer cancel()
+
+	blockchainConfig, err := m.api.GetBlockchainConfig(ctx, block, ConfigCurrentValidators)
+	if err != nil {
+		return
+	}
+	vsetHash := blockchainConfig.Get(ConfigCurrentValidators).Hash()
+	if m.vsetHash != nil && !bytes.Equal(m.vsetHash, vsetHash) {
+		log.Printf("🔄 Validator set changed in block %v", block.SeqNo)
+		m.emit(EventProcess)
+		m.emit(EventRequest)
+	}
+	m.vsetHash = vsetHash
+
+	now := uint32(time.Now().Unix())
+	for deadline := range m.deadlines {
+		if now >= deadline {
+			delete(m.deadlines, deadline)
+			m.emit(EventProcess)
+		}
+	}
+}
+
+// onTransaction refreshes the states of rounds after a treasury transaction that may change them, and ignores the
+// deposits and withdrawals of stakers.
+func (m *monitor) onTransaction(tx *tlb.Transaction) {
+	if tx.IO.In == nil {
+		return
+	}
+	body := tx.IO.In.Msg.Payload()
+	if body == nil {
+		return
+	}
+	op, err := body.BeginParse().LoadUInt(32)
+	if err != nil || !isMonitoredOp(uint32(op)) {
+		return
+	}
+	log.Printf("📨 Treasury received %v in transaction %v", OpName(uint32(op)), tx.LT)
+	m.refreshStates()
+}
+
+func isMonitoredOp(op uint32) bool {
+	return op == ParticipateInElection || op == VsetChanged || op == FinishParticipation || op == LoanRequest
+}
+
+// refreshStates loads the participations of the treasury and sends an event when the state of a round changes.
+func (m *monitor) refreshStates() {
+	mainchainInfo := loadMainchainInfo(m.api, m.ctx)
+
+	participations, _ := loadTreasuryState(m.api, m.ctx, mainchainInfo, m.treasuryAddress)
+
+	participateSince := getParticipateSince(m.api, m.ctx, mainchainInfo, m.treasuryAddress)
+
+	states := map[uint32]ParticipationState{}
+	if participations != nil {
+		for _, kv := range participations.All() {
+			roundSince := uint32(kv.Key.BeginParse().MustLoadUInt(32))
+			participation := LoadParticipation(kv.Value)
+			states[roundSince] = participation.State
+
+			if participation.State == ParticipationOpen {
+				m.deadlines[participateSince] = true
+			} else if participation.State == ParticipationHeld {
+				m.deadlines[participation.StakeHeldUntil] = true
+			}
+		}
+	}
+
+	if m.states == nil {
+		m.states = states
+		return
+	}
+
+	changed := len(states) != len(m.states)
+	for roundSince, state := range states {
+		previous, found := m.states[roundSince]
+		if !found || previous != state {
+			formattedRoundSince := time.Unix(int64(roundSince), 0).Format(TimeFormat)
+			log.Printf("🔀 Round %v is now %v", formattedRoundSince, state)
+			changed = true
+		}
+	}
+	m.states = states
+
+	if changed {
+		m.emit(EventProcess)
+	}
+}
@@ -0,0 +1,53 @@
+//go:build linux
+
+package borrower
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+// promptPassphrase asks for a new passphrase twice on the terminal, without echoing it.
+func promptPassphrase() ([]byte, error) {
+	fd := int(os.Stdin.Fd())
+	termios, err := unix.IoctlGetTermios(fd, unix.TCGETS)
+	if err != nil {
+		return nil, errNoPassphrase
+	}
+	silent := *termios
+	silent.Lflag &^= unix.ECHO
+	err = unix.IoctlSetTermios(fd, unix.TCSETS, &silent)
+	if err != nil {
+		return nil, err
+	}
+	defer unix.IoctlSetTermios(fd, unix.TCSETS, termios)
+
+	reader := bufio.NewReader(os.Stdin)
+	read := func(prompt string) ([]byte, error) {
+		fmt.Fprint(os.Stderr, prompt)
+		line, err := reader.ReadBytes('\n')
+		fmt.Fprintln(os.Stderr)
+		return bytes.TrimRight(line, "\r\n"), err
+	}
+
+	first, err := read("Passphrase: ")
+	if err != nil {
+		return nil, err
+	}
+	if len(first) == 0 {
+		return nil, errors.New("empty passphrase")
+	}
+	second, err := read("Repeat passphrase: ")
+	if err != nil {
+		return nil, err
+	}
+	if !bytes.Equal(first, second) {
+		return nil, errors.New("passphrases don't match")
+	}
+	return first, nil
+}
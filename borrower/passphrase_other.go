@@ -0,0 +1,8 @@
+//go:build !linux
+
+package borrower
+
+// promptPassphrase is only supported on Linux, elsewhere the passphrase has to come from the environment.
+func promptPassphrase() ([]byte, error) {
+	return nil, errNoPassphrase
+}
@@ -2,12 +2,14 @@ package borrower
 
 import (
 	"context"
+	"crypto/ed25519"
 	"encoding/hex"
+	"errors"
 	"fmt"
 	"log"
 	"math/big"
-	"os"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/xssnick/tonutils-go/address"
@@ -30,6 +32,8 @@ func Process() (wait time.Duration) {
 
 	api, ctx := loadApi(config)
 
+	store := loadStore(config)
+
 	treasuryAddress := address.MustParseAddr(config.Treasury)
 
 	mainchainInfo := loadMainchainInfo(api, ctx)
@@ -40,24 +44,39 @@ func Process() (wait time.Duration) {
 
 	participateSince := getParticipateSince(api, ctx, mainchainInfo, treasuryAddress)
 
+	keeper := newKeeper(api, ctx, store, mainchainInfo, treasuryAddress)
+
+	alerter := newAlerter(config.Alerts, store)
+
 	participationsList := []*cell.HashmapKV{}
 	if participations != nil {
 		participationsList = participations.All()
 	}
 
+	resetGauge("borrower_round_state")
+	resetGauge("borrower_round_state_dwell_seconds")
+
+	rounds := map[uint32]bool{}
 	for _, kv := range participationsList {
 		roundSince := uint32(kv.Key.BeginParse().MustLoadUInt(32))
 		participation := LoadParticipation(kv.Value)
 		formattedRoundSince := time.Unix(int64(roundSince), 0).Format(TimeFormat)
-		log.Printf("ℹ️  Round: %v, state: %v", formattedRoundSince, participation.State)
+		observation := store.observe(roundSince, participation.State)
+		dwell := time.Since(time.Unix(observation.Since, 0)).Round(time.Second)
+		log.Printf("ℹ️  Round: %v, state: %v for %v", formattedRoundSince, participation.State, dwell)
+		rounds[roundSince] = true
+		exposeObservation(observation)
+		keeper.resolve(roundSince, participation.State)
 		roundParticipateTime := participateSince
 		if roundSince < participateSince {
 			roundParticipateTime = roundSince
 		}
+		if expectedEnd, ok :=
+			expectedStateEnd(observation, &participation, roundParticipateTime, validatorsElectedFor); ok {
+			checkDwell(alerter, config.Alerts, observation, expectedEnd)
+		}
 		now := uint32(time.Now().Unix())
 		vsetChanged := participation.CurrentVsetHash.Cmp(currentVsetHash) != 0
-		ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
-		defer cancel()
 
 		if participation.State == ParticipationOpen {
 			if now < roundParticipateTime {
@@ -66,20 +85,7 @@ func Process() (wait time.Duration) {
 					wait = next
 				}
 			} else {
-				err := api.SendExternalMessage(ctx, &tlb.ExternalMessage{
-					DstAddr: treasuryAddress,
-					Body: cell.BeginCell().
-						MustStoreUInt(ParticipateInElection, 32).
-						MustStoreUInt(uint64(now), 64).
-						MustStoreUInt(uint64(roundSince), 32).
-						EndCell(),
-				})
-				if err != nil {
-					log.Printf("⚠️  Failed to send participate_in_election for round %v", formattedRoundSince)
-				} else {
-					log.Printf("☑️  Sent participate_in_election for round %v", formattedRoundSince)
-				}
-				next := 30 * time.Second
+				next := keeper.trigger(ParticipateInElection, roundSince, participation.State, "participate_in_election")
 				if wait == 0 || wait > next {
 					wait = next
 				}
@@ -98,20 +104,7 @@ func Process() (wait time.Duration) {
 					wait = next
 				}
 			} else {
-				err := api.SendExternalMessage(ctx, &tlb.ExternalMessage{
-					DstAddr: treasuryAddress,
-					Body: cell.BeginCell().
-						MustStoreUInt(VsetChanged, 32).
-						MustStoreUInt(uint64(now), 64).
-						MustStoreUInt(uint64(roundSince), 32).
-						EndCell(),
-				})
-				if err != nil {
-					log.Printf("⚠️  Failed to send validating vset_changed for round %v", formattedRoundSince)
-				} else {
-					log.Printf("☑️  Sent validating vset_changed for round %v", formattedRoundSince)
-				}
-				next := 30 * time.Second
+				next := keeper.trigger(VsetChanged, roundSince, participation.State, "validating vset_changed")
 				if wait == 0 || wait > next {
 					wait = next
 				}
@@ -124,20 +117,7 @@ func Process() (wait time.Duration) {
 					wait = next
 				}
 			} else {
-				err := api.SendExternalMessage(ctx, &tlb.ExternalMessage{
-					DstAddr: treasuryAddress,
-					Body: cell.BeginCell().
-						MustStoreUInt(VsetChanged, 32).
-						MustStoreUInt(uint64(now), 64).
-						MustStoreUInt(uint64(roundSince), 32).
-						EndCell(),
-				})
-				if err != nil {
-					log.Printf("⚠️  Failed to send held vset_changed for round %v", formattedRoundSince)
-				} else {
-					log.Printf("☑️  Sent held vset_changed for round %v", formattedRoundSince)
-				}
-				next := 30 * time.Second
+				next := keeper.trigger(VsetChanged, roundSince, participation.State, "held vset_changed")
 				if wait == 0 || wait > next {
 					wait = next
 				}
@@ -151,32 +131,38 @@ func Process() (wait time.Duration) {
 				}
 
 			} else {
-				err := api.SendExternalMessage(ctx, &tlb.ExternalMessage{
-					DstAddr: treasuryAddress,
-					Body: cell.BeginCell().
-						MustStoreUInt(FinishParticipation, 32).
-						MustStoreUInt(uint64(now), 64).
-						MustStoreUInt(uint64(roundSince), 32).
-						EndCell(),
-				})
-				if err != nil {
-					log.Printf("⚠️  Failed to send finish_participation for round %v", formattedRoundSince)
-				} else {
-					log.Printf("☑️  Sent finish_participation for round %v", formattedRoundSince)
-				}
-				next := 30 * time.Second
+				next := keeper.trigger(FinishParticipation, roundSince, participation.State, "finish_participation")
 				if wait == 0 || wait > next {
 					wait = next
 				}
 			}
+
+		} else if participation.State == ParticipationRecovering || participation.State == ParticipationBurning {
+			next := checkRecovery(alerter, config.Alerts.RecoveryTimeout, roundSince, &participation)
+			if wait == 0 || wait > next {
+				wait = next
+			}
 		}
 	}
 
+	next := sweepSurplus(config, api, ctx, store, alerter, mainchainInfo, treasuryAddress, rounds)
+	if next > 0 && (wait == 0 || wait > next) {
+		wait = next
+	}
+
+	keeper.prune(rounds)
+
+	store.pruneObservations(rounds)
+
+	store.pruneSweeps()
+
+	alerter.prune()
+
 	t := participateSince + 60
 	if uint32(time.Now().Unix()) > t {
 		t = nextRoundSince
 	}
-	next := time.Until(time.Unix(int64(t), 0))
+	next = time.Until(time.Unix(int64(t), 0))
 	if wait == 0 || wait > next {
 		wait = next
 	}
@@ -184,6 +170,47 @@ func Process() (wait time.Duration) {
 	return
 }
 
+// checkRecovery reports the progress of recovering stakes of a round, and raises an alert when it takes longer than
+// the timeout after the end of the held period.
+func checkRecovery(alerter *alerter, timeout time.Duration, roundSince uint32,
+	participation *Participation) time.Duration {
+	formattedRoundSince := time.Unix(int64(roundSince), 0).Format(TimeFormat)
+	if timeout <= 0 {
+		timeout = 1 * time.Hour
+	}
+
+	if participation.State == ParticipationRecovering {
+		log.Printf("ℹ️  Recovering %v of %v loans of round %v, recovered %v of %v TON",
+			dictionarySize(participation.Recovering), dictionarySize(participation.Staked), formattedRoundSince,
+			tlb.FromNanoTON(participation.TotalRecovered).String(), tlb.FromNanoTON(participation.TotalStaked).String())
+	} else {
+		log.Printf("ℹ️  Burning for round %v, recovered %v of %v TON", formattedRoundSince,
+			tlb.FromNanoTON(participation.TotalRecovered).String(), tlb.FromNanoTON(participation.TotalStaked).String())
+	}
+
+	deadline := time.Unix(int64(participation.StakeHeldUntil), 0).Add(timeout)
+	if time.Now().After(deadline) {
+		alerter.raise(fmt.Sprintf("recovery:%d", roundSince), AlertCritical,
+			"Round %v is still %v %v after the end of its held period, recovered %v of %v TON",
+			formattedRoundSince, participation.State, timeout,
+			tlb.FromNanoTON(participation.TotalRecovered).String(), tlb.FromNanoTON(participation.TotalStaked).String())
+	}
+
+	// Recovering needs a few messages between loans, the elector, and the treasury, so check again soon
+	next := 1 * time.Minute
+	if until := time.Until(deadline); until > 0 && until < next {
+		next = until
+	}
+	return next
+}
+
+func dictionarySize(d *cell.Dictionary) int {
+	if d == nil {
+		return 0
+	}
+	return len(d.All())
+}
+
 func RequestLoan() (wait time.Duration) {
 	defer func() {
 		if err := recover(); err != nil {
@@ -197,6 +224,7 @@ func RequestLoan() (wait time.Duration) {
 	api, ctx := loadApi(config)
 
 	engine := NewValidatorEngine(config.ValidatorEngine)
+	defer engine.Close()
 
 	treasuryAddress := address.MustParseAddr(config.Treasury)
 
@@ -208,6 +236,8 @@ func RequestLoan() (wait time.Duration) {
 
 	participations, stopped := loadTreasuryState(api, ctx, mainchainInfo, treasuryAddress)
 
+	logFailure("clean up expired keys", func() { cleanupKeysIfEnabled(config, engine, participations) })
+
 	formattedNextRoundSince := time.Unix(int64(nextRoundSince), 0).Format(TimeFormat)
 
 	wait = time.Until(time.Unix(int64(nextRoundSince+stakeHeldFor+60), 0))
@@ -217,21 +247,46 @@ func RequestLoan() (wait time.Duration) {
 		return 0
 	}
 
-	adnlAddressBigInt := loadAdnlAddress(config.ValidatorEngine.AdnlAddress)
+	adnlAddress := resolveAdnlAddress(engine.GetConfig(), config.ValidatorEngine.AdnlAddress)
+	if config.ValidatorEngine.AdnlAddress == "" {
+		log.Printf("   🔎 Using ADNL address %v of the validator engine", adnlAddress)
+	}
+
+	adnlAddressBigInt := loadAdnlAddress(adnlAddress)
 
 	w := loadWallet(config.Wallet, api)
 
+	verifyWalletAddress(config.Wallet, w)
+
+	verifyWalletNetwork(config.Wallet, treasuryAddress, api, ctx, mainchainInfo)
+
 	validatorAddress := w.Address()
 	validatorAddress.SetTestnetOnly(treasuryAddress.IsTestnetOnly())
 	validatorKey := cell.BeginCell().MustStoreBigUInt(new(big.Int).SetBytes(validatorAddress.Data()), 256).EndCell()
 
+	store := loadStore(config)
+
+	logFailure("index wallet transactions", func() {
+		reconcileWallet(config, api, ctx, mainchainInfo, store, validatorAddress, treasuryAddress,
+			validatorsElectedFor+stakeHeldFor)
+	})
+
 	loanAddress := loadLoanAddress(validatorAddress, treasuryAddress, nextRoundSince, api, ctx, mainchainInfo)
 
 	stake, loan, minPayment, maxFactor, validatorRewardShare := loadBorrowConfig(config.Borrow, minStake)
 
+	logFailure("check max factor", func() { warnMaxFactor(api, ctx, mainchainInfo, maxFactor) })
+
 	maxPunishment := getMaxPunishment(api, ctx, mainchainInfo, treasuryAddress, loan)
 
-	requestLoanFee := getRequestLoanFee(api, ctx, mainchainInfo, treasuryAddress)
+	requestLoanFee :=
+		getRequestLoanFee(api, ctx, mainchainInfo, treasuryAddress, loadMaxRequestLoanFee(config.Borrow))
+
+	balance := loadBalance(w, mainchainInfo)
+
+	logFailure("forecast funding", func() {
+		checkFunding(config, api, ctx, mainchainInfo, store, treasuryAddress, validatorAddress, balance)
+	})
 
 	if stopped {
 		log.Printf("   🔲 Treasury is stopped")
@@ -257,25 +312,33 @@ func RequestLoan() (wait time.Duration) {
 		return
 	}
 
-	value := big.NewInt(1000000000)
-	if maxPunishment.Cmp(value) == 1 {
-		value = maxPunishment
-	}
-	value = value.Add(value, requestLoanFee)
-	value = value.Add(value, minPayment)
-	value = value.Add(value, stake)
+	value := getRequestValue(maxPunishment, requestLoanFee, minPayment, stake)
 
-	balance := loadBalance(w, mainchainInfo)
 	if balance.Cmp(value) != 1 {
-		log.Printf("   ⚠️  Low balance, need at least %v TON, but your wallet balance is %v TON",
-			tlb.FromNanoTON(value).String(), tlb.FromNanoTON(balance).String())
+		topUp := new(big.Int).Sub(value, balance)
+		topUp.Add(topUp, withdrawFeeMargin)
+		log.Printf("   ⚠️  Low balance, need at least %v TON, but your wallet balance is %v TON, top up with %v",
+			tlb.FromNanoTON(value).String(), tlb.FromNanoTON(balance).String(), topUpLink(validatorAddress, topUp))
+		return 0
+	}
+
+	readiness := checkReadiness(config, api, ctx, engine, mainchainInfo, treasuryAddress)
+	if !readiness.Ready() {
+		for _, c := range readiness.Failures() {
+			log.Printf("   🩺 %v: %v", c.Name, c.Detail)
+		}
+		log.Printf("   ⚠️  Not ready to request a loan, run borrower doctor for details")
 		return 0
 	}
 
 	log.Printf("   🛠  Configuring validator engine for round %v", formattedNextRoundSince)
 
 	keyHash, publicKey :=
-		createValidationKey(engine, nextRoundSince, validatorsElectedFor, config.ValidatorEngine.AdnlAddress)
+		createValidationKey(engine, nextRoundSince, validatorsElectedFor, adnlAddress)
+
+	log.Printf("   🧾 Sending %v TON for max punishment, %v TON for request loan fee, %v TON for min payment, "+
+		"and %v TON of stake", tlb.FromNanoTON(getPunishmentDeposit(maxPunishment)).String(),
+		tlb.FromNanoTON(requestLoanFee).String(), tlb.FromNanoTON(minPayment).String(), tlb.FromNanoTON(stake).String())
 
 	log.Printf("   💎 Requesting a loan of %v TON, sending %v TON, for validation round %v",
 		tlb.FromNanoTON(loan).String(), tlb.FromNanoTON(value), formattedNextRoundSince)
@@ -290,6 +353,8 @@ func RequestLoan() (wait time.Duration) {
 
 	signature := engine.Sign(keyHash, confirmation)
 
+	verifySignature(publicKey, confirmation, signature)
+
 	newStakeMsg := cell.BeginCell().
 		MustStoreBigUInt(new(big.Int).SetBytes(publicKey), 256).
 		MustStoreUInt(uint64(nextRoundSince), 32).
@@ -298,9 +363,11 @@ func RequestLoan() (wait time.Duration) {
 		MustStoreRef(cell.BeginCell().MustStoreSlice(signature, 512).EndCell()).
 		EndCell()
 
+	queryId := uint64(time.Now().Unix())
+
 	payload := cell.BeginCell().
-		MustStoreUInt(0x36335da9, 32).
-		MustStoreUInt(uint64(time.Now().Unix()), 64).
+		MustStoreUInt(LoanRequest, 32).
+		MustStoreUInt(queryId, 64).
 		MustStoreUInt(uint64(nextRoundSince), 32).
 		MustStoreBigCoins(loan).
 		MustStoreBigCoins(minPayment).
@@ -310,13 +377,38 @@ func RequestLoan() (wait time.Duration) {
 
 	message := wallet.SimpleMessage(treasuryAddress, tlb.FromNanoTON(value), payload)
 
-	sendRequestLoan(w, message)
+	tx := sendWalletMessage(w, message)
+
+	store.recordLoanRequest(nextRoundSince, loanAddress,
+		int64(nextRoundSince+validatorsElectedFor+stakeHeldFor), &LoanRequestRecord{
+			QueryId:    queryId,
+			SentAt:     time.Now().Unix(),
+			Lt:         tx.LT,
+			Hash:       hex.EncodeToString(tx.Hash),
+			Value:      tlb.FromNanoTON(value),
+			Collateral: tlb.FromNanoTON(getPunishmentDeposit(maxPunishment)),
+			Fee:        tlb.FromNanoTON(requestLoanFee),
+			MinPayment: tlb.FromNanoTON(minPayment),
+			Stake:      tlb.FromNanoTON(stake),
+			Loan:       tlb.FromNanoTON(loan),
+		})
 
 	log.Printf("   ✅ Sent a loan request for round %v", formattedNextRoundSince)
 
 	return
 }
 
+// logFailure runs a step of RequestLoan that only reports or cleans up, and logs its failure instead of panicking,
+// since such a step must never stop a loan request.
+func logFailure(name string, f func()) {
+	defer func() {
+		if err := recover(); err != nil {
+			log.Printf("   ⚠️  Failed to %v: %v", name, err)
+		}
+	}()
+	f()
+}
+
 func loadConfig() *Config {
 	config, err := ReadConfig()
 	if err != nil {
@@ -453,7 +545,44 @@ func getMaxPunishment(api ton.APIClientWrapped, ctx context.Context, mainchainIn
 	return maxPunishment.MustInt(0)
 }
 
+// requestLoanFee is cached for the block it was loaded for, since the value of a loan request is needed several
+// times for each block.
+var requestLoanFee struct {
+	sync.Mutex
+	seqno    uint32
+	treasury string
+	value    *big.Int
+}
+
+// getRequestLoanFee returns the fee that the treasury charges for a loan request, which is the first result of its
+// get_treasury_fees getter. Older treasuries take no arguments, and newer ones take ownership_assigned_amount, so
+// the getter is called again with an argument when the stack underflows. A fee above maxFee is rejected, so a
+// broken or malicious treasury can't take more than expected.
 func getRequestLoanFee(api ton.APIClientWrapped, ctx context.Context, mainchainInfo *ton.BlockIDExt,
+	treasuryAddress *address.Address, maxFee *big.Int) *big.Int {
+	requestLoanFee.Lock()
+	defer requestLoanFee.Unlock()
+
+	if requestLoanFee.value == nil || requestLoanFee.seqno != mainchainInfo.SeqNo ||
+		requestLoanFee.treasury != treasuryAddress.StringRaw() {
+		requestLoanFee.value = loadRequestLoanFee(api, ctx, mainchainInfo, treasuryAddress)
+		requestLoanFee.seqno = mainchainInfo.SeqNo
+		requestLoanFee.treasury = treasuryAddress.StringRaw()
+	}
+	fee := requestLoanFee.value
+
+	if fee.Sign() <= 0 {
+		panic(fmt.Sprintf("Error, treasury request loan fee is %v TON", tlb.FromNanoTON(fee).String()))
+	}
+	if fee.Cmp(maxFee) > 0 {
+		panic(fmt.Sprintf("Error, treasury request loan fee is %v TON, which is more than max_request_loan_fee %v TON",
+			tlb.FromNanoTON(fee).String(), tlb.FromNanoTON(maxFee).String()))
+	}
+
+	return new(big.Int).Set(fee)
+}
+
+func loadRequestLoanFee(api ton.APIClientWrapped, ctx context.Context, mainchainInfo *ton.BlockIDExt,
 	treasuryAddress *address.Address) *big.Int {
 	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
 	defer cancel()
@@ -467,13 +596,19 @@ func getRequestLoanFee(api ton.APIClientWrapped, ctx context.Context, mainchainI
 		panic("Error, treasury account is not active")
 	}
 
-	// treasuryFees, err := api.RunGetMethod(ctx, mainchainInfo, treasuryAddress, "get_treasury_fees", 0)
-	// if err != nil {
-	// 	panic(fmt.Sprintf("Error in getting treasury fees: %v", err))
-	// }
+	treasuryFees, err := api.RunGetMethod(ctx, mainchainInfo, treasuryAddress, "get_treasury_fees")
+	if errors.Is(err, ton.ContractExecError{Code: 2}) {
+		treasuryFees, err = api.RunGetMethod(ctx, mainchainInfo, treasuryAddress, "get_treasury_fees", 0)
+	}
+	if err != nil {
+		panic(fmt.Sprintf("Error in getting treasury fees: %v", err))
+	}
 
-	// return treasuryFees.MustInt(0)
-	return big.NewInt(1000000000)
+	fee, err := treasuryFees.Int(0)
+	if err != nil {
+		panic(fmt.Sprintf("Error in getting request loan fee from treasury fees: %v", err))
+	}
+	return fee
 }
 
 func loadAdnlAddress(adnlAddress string) *big.Int {
@@ -486,35 +621,33 @@ func loadAdnlAddress(adnlAddress string) *big.Int {
 	return adnlAddressBigInt
 }
 
-func loadWallet(config Wallet, api ton.APIClientWrapped) *wallet.Wallet {
-	var version wallet.Version
-	if config.Version == "v4r2" {
-		version = wallet.V4R2
-	} else if config.Version == "v3r2" {
-		version = wallet.V3R2
-	} else {
-		panic(fmt.Sprintf("Error, invalid wallet version, expected v4r2 or v3r2 but got: %v", config.Version))
-	}
-
-	secret, err := os.ReadFile(config.Path)
-	if err != nil {
-		panic(fmt.Sprintf("Error in reading wallet secret: %v", err))
-	}
+// getRequestValue returns the TON amount sent with a loan request: the max punishment (at least 1 TON), the fee of
+// the request, the min payment, and the stake.
+func getRequestValue(maxPunishment, requestLoanFee, minPayment, stake *big.Int) *big.Int {
+	value := getPunishmentDeposit(maxPunishment)
+	value.Add(value, requestLoanFee)
+	value.Add(value, minPayment)
+	value.Add(value, stake)
+	return value
+}
 
-	var w *wallet.Wallet
-	if config.Type == "mnemonic" {
-		seed := strings.Split(strings.Trim(string(secret), " \n\t"), " ")
-		w, err = wallet.FromSeed(api, seed, version)
-	} else if config.Type == "binary" {
-		w, err = wallet.FromPrivateKey(api, secret, version)
-	} else {
-		panic(fmt.Sprintf("Error, invalid wallet type, expected mnemonic or binary but got: %v", config.Type))
-	}
-	if err != nil {
-		panic(fmt.Sprintf("Error in loading wallet: %v", err))
+// getPunishmentDeposit returns the part of the request value that covers the max punishment, which is at least 1 TON.
+func getPunishmentDeposit(maxPunishment *big.Int) *big.Int {
+	deposit := big.NewInt(1000000000)
+	if maxPunishment.Cmp(deposit) == 1 {
+		deposit.Set(maxPunishment)
 	}
+	return deposit
+}
 
-	return w
+// loadRequestValue returns the value sent with a loan request for the next round, which is reserved in the wallet.
+func loadRequestValue(config Borrow, api ton.APIClientWrapped, ctx context.Context, mainchainInfo *ton.BlockIDExt,
+	treasuryAddress *address.Address) *big.Int {
+	_, minStake, _, _, _ := loadBlockchainConfig(api, ctx, mainchainInfo)
+	stake, loan, minPayment, _, _ := loadBorrowConfig(config, minStake)
+	maxPunishment := getMaxPunishment(api, ctx, mainchainInfo, treasuryAddress, loan)
+	requestLoanFee := getRequestLoanFee(api, ctx, mainchainInfo, treasuryAddress, loadMaxRequestLoanFee(config))
+	return getRequestValue(maxPunishment, requestLoanFee, minPayment, stake)
 }
 
 func loadLoanAddress(validatorAddress *address.Address, treasuryAddress *address.Address, nextRoundSince uint32,
@@ -566,11 +699,26 @@ func loadBorrowConfig(config Borrow, minStake *big.Int) (*big.Int, *big.Int, *bi
 	if config.MaxFactorRatio < 1 {
 		panic("Error, max_factor_ratio must be >= 1.0")
 	}
+	if config.MaxFactorRatio >= 65536 {
+		panic("Error, max_factor_ratio must be < 65536")
+	}
 	maxFactor := uint32(config.MaxFactorRatio * 65536)
 
 	return stake.Nano(), loan.Nano(), minPayment.Nano(), maxFactor, config.ValidatorRewardShare
 }
 
+// loadMaxRequestLoanFee returns the highest request loan fee of the treasury that is paid, 5 TON by default.
+func loadMaxRequestLoanFee(config Borrow) *big.Int {
+	if config.MaxRequestLoanFee == "" {
+		return tlb.MustFromTON("5").Nano()
+	}
+	maxFee, err := tlb.FromTON(config.MaxRequestLoanFee)
+	if err != nil {
+		panic("Error, invalid max_request_loan_fee amount")
+	}
+	return maxFee.Nano()
+}
+
 func loadBalance(w *wallet.Wallet, mainchainInfo *ton.BlockIDExt) *big.Int {
 	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 	defer cancel()
@@ -585,31 +733,82 @@ func loadBalance(w *wallet.Wallet, mainchainInfo *ton.BlockIDExt) *big.Int {
 
 func createValidationKey(engine *Engine, nextRoundSince, validatorsElectedFor uint32,
 	adnlAddress string) (string, []byte) {
-	keyHash := engine.FindPermKeyIfExists(nextRoundSince)
+	keyHash, fixes := reconcileValidationKey(engine, nextRoundSince, nextRoundSince+validatorsElectedFor, adnlAddress)
+	for _, fix := range fixes {
+		log.Printf("   🔧 %v", fix)
+	}
 
-	if keyHash == "" {
-		expireAt := nextRoundSince + validatorsElectedFor
+	publicKey := engine.ExportPub(keyHash)
 
-		keyHash = engine.NewKey()
+	return keyHash, publicKey
+}
 
-		engine.AddPermKey(keyHash, nextRoundSince, expireAt)
+// reconcileValidationKey makes sure the permanent key of the round, its temp key, and the validator ADNL address all
+// exist in the engine and don't expire before expireAt. It adds whatever is missing, so that a previous run which
+// stopped halfway is repaired, and returns the key hash and a description of what was fixed.
+func reconcileValidationKey(engine *Engine, roundSince uint32, expireAt uint32,
+	adnlAddress string) (keyHash string, fixes []string) {
+	validator := engine.GetConfig().FindValidator(roundSince)
 
+	if validator == nil {
+		keyHash = engine.NewKey()
+		engine.AddPermKey(keyHash, roundSince, expireAt)
+		fixes = append(fixes, fmt.Sprintf("Created permanent key %v", keyHash))
+		validator = &ValidatorConfig{}
+	} else {
+		keyHash = validator.Id.Hex()
+		if keyHash == "" {
+			panic(fmt.Sprintf("Error, invalid permanent key in engine config: %v", validator.Id))
+		}
+		if validator.ExpireAt < expireAt {
+			engine.AddPermKey(keyHash, roundSince, expireAt)
+			fixes = append(fixes, fmt.Sprintf("Extended expiry of permanent key %v to %v", keyHash, formatUnix(expireAt)))
+		}
+	}
+
+	hasTempKey := false
+	for _, t := range validator.TempKeys {
+		if strings.EqualFold(t.Key.Hex(), keyHash) && t.ExpireAt >= expireAt {
+			hasTempKey = true
+		}
+	}
+	if !hasTempKey {
 		engine.AddTempKey(keyHash, expireAt)
+		fixes = append(fixes, fmt.Sprintf("Added temp key of %v until %v", keyHash, formatUnix(expireAt)))
+	}
 
+	hasAdnlAddress := false
+	for _, a := range validator.AdnlAddrs {
+		if strings.EqualFold(a.Id.Hex(), adnlAddress) && a.ExpireAt >= expireAt {
+			hasAdnlAddress = true
+		}
+	}
+	if !hasAdnlAddress {
 		engine.AddValidatorAddr(keyHash, adnlAddress, expireAt)
+		fixes = append(fixes, fmt.Sprintf("Added validator ADNL address %v to %v until %v",
+			adnlAddress, keyHash, formatUnix(expireAt)))
 	}
 
-	publicKey := engine.ExportPub(keyHash)
-
-	return keyHash, publicKey
+	return
 }
 
-func sendRequestLoan(w *wallet.Wallet, message *wallet.Message) {
-	ctx, cancel := context.WithTimeout(context.Background(), 120*time.Second)
-	defer cancel()
-
-	_, _, err := w.SendWaitTransaction(ctx, message)
-	if err != nil {
-		panic(fmt.Sprintf("Error in sending loan request: %v", err))
+// verifySignature checks the signature of the new_stake confirmation locally, the same way the elector does, so that
+// a wrong key or an unexpected output of the engine doesn't cost the fees and the round.
+func verifySignature(publicKey []byte, confirmation *cell.Cell, signature []byte) {
+	if len(signature) != ed25519.SignatureSize {
+		panic(fmt.Sprintf("Error, invalid signature length of new_stake confirmation, expected %v bytes but got %v",
+			ed25519.SignatureSize, len(signature)))
+	}
+	if len(publicKey) != ed25519.PublicKeySize {
+		panic(fmt.Sprintf("Error, invalid public key length of validation key, expected %v bytes but got %v",
+			ed25519.PublicKeySize, len(publicKey)))
 	}
+	if !ed25519.Verify(publicKey, cellData(confirmation), signature) {
+		panic(fmt.Sprintf("Error, signature of new_stake confirmation is not valid for public key %x", publicKey))
+	}
+}
+
+// cellData returns the data bits of a byte aligned cell.
+func cellData(c *cell.Cell) []byte {
+	return c.BeginParse().MustLoadSlice(c.BitsSize())
 }
@@ -0,0 +1,327 @@
+package borrower
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net"
+	"os"
+	"syscall"
+	"text/tabwriter"
+	"time"
+
+	"github.com/xssnick/tonutils-go/address"
+	"github.com/xssnick/tonutils-go/liteclient"
+	"github.com/xssnick/tonutils-go/tlb"
+	"github.com/xssnick/tonutils-go/ton"
+	"github.com/xssnick/tonutils-go/ton/wallet"
+)
+
+type CheckStatus string
+
+const (
+	CheckPass CheckStatus = "pass"
+	CheckWarn CheckStatus = "warn"
+	CheckFail CheckStatus = "fail"
+	CheckSkip CheckStatus = "skip"
+)
+
+type Check struct {
+	Name   string      `json:"name"`
+	Status CheckStatus `json:"status"`
+	Detail string      `json:"detail"`
+}
+
+// Readiness is the report of the checks that run before requesting a loan.
+type Readiness struct {
+	Checks []Check `json:"checks"`
+}
+
+// Ready is true when no check has failed. Warnings don't stop a loan request, and a check is only skipped when a
+// check it depends on has failed.
+func (r *Readiness) Ready() bool {
+	for _, c := range r.Checks {
+		if c.Status == CheckFail {
+			return false
+		}
+	}
+	return true
+}
+
+func (r *Readiness) Failures() []Check {
+	failures := []Check{}
+	for _, c := range r.Checks {
+		if c.Status == CheckFail {
+			failures = append(failures, c)
+		}
+	}
+	return failures
+}
+
+func (r *Readiness) Print(out io.Writer) {
+	w := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "CHECK\tSTATUS\tDETAIL")
+	for _, c := range r.Checks {
+		fmt.Fprintf(w, "%v\t%v\t%v\n", c.Name, c.Status, c.Detail)
+	}
+	w.Flush()
+}
+
+// check runs one check, and turns its panic into a failure so that the other checks still run.
+func (r *Readiness) check(name string, f func() (CheckStatus, string)) {
+	var status CheckStatus
+	var detail string
+	func() {
+		defer func() {
+			if err := recover(); err != nil {
+				status = CheckFail
+				detail = fmt.Sprint(err)
+			}
+		}()
+		status, detail = f()
+	}()
+	r.Checks = append(r.Checks, Check{Name: name, Status: status, Detail: detail})
+}
+
+func checkReadiness(config *Config, api ton.APIClientWrapped, ctx context.Context, engine *Engine,
+	mainchainInfo *ton.BlockIDExt, treasuryAddress *address.Address) *Readiness {
+	r := &Readiness{}
+
+	r.check(fmt.Sprintf("Engine sync (%v)", engine.Protocol()), func() (CheckStatus, string) {
+		if !engine.IsSync() {
+			return CheckFail, "The last masterchain block of the engine is older than 60 seconds"
+		}
+		return CheckPass, "The engine has a recent masterchain block"
+	})
+
+	r.check("Engine sync (liteserver)", func() (CheckStatus, string) {
+		return checkLocalLiteserver(config.ValidatorEngine, mainchainInfo)
+	})
+
+	var engineConfig *EngineConfig
+	r.check("ADNL address", func() (CheckStatus, string) {
+		engineConfig = engine.GetConfig()
+		adnlAddress := resolveAdnlAddress(engineConfig, config.ValidatorEngine.AdnlAddress)
+		return CheckPass, fmt.Sprintf("Validating with %v", adnlAddress)
+	})
+
+	var w *wallet.Wallet
+	r.check("Wallet", func() (CheckStatus, string) {
+		w = loadWallet(config.Wallet, api)
+		verifyWalletAddress(config.Wallet, w)
+		verifyWalletNetwork(config.Wallet, treasuryAddress, api, ctx, mainchainInfo)
+		return checkWalletDeployed(api, ctx, mainchainInfo, w, parseWalletVersion(config.Wallet))
+	})
+
+	var nextRoundSince, maxFactor uint32
+	networkLoaded := false
+	r.check("Network config", func() (CheckStatus, string) {
+		_, minStake, _, roundSince, _ := loadBlockchainConfig(api, ctx, mainchainInfo)
+		_, loan, _, ratio, _ := loadBorrowConfig(config.Borrow, minStake)
+		nextRoundSince, maxFactor, networkLoaded = roundSince, ratio, true
+		if loan.Cmp(minStake) < 0 {
+			return CheckFail, fmt.Sprintf("Loan of %v TON is less than min_stake of %v TON",
+				tlb.FromNanoTON(loan).String(), tlb.FromNanoTON(minStake).String())
+		}
+		return CheckPass, fmt.Sprintf("Loan of %v TON is at least min_stake of %v TON",
+			tlb.FromNanoTON(loan).String(), tlb.FromNanoTON(minStake).String())
+	})
+
+	r.check("Max factor", func() (CheckStatus, string) {
+		if !networkLoaded {
+			return CheckSkip, "Network config is not loaded"
+		}
+		return checkMaxFactor(maxFactor, loadElectionLimits(api, ctx, mainchainInfo))
+	})
+
+	r.check("Loan address", func() (CheckStatus, string) {
+		if w == nil {
+			return CheckSkip, "Wallet is not loaded"
+		}
+		if !networkLoaded {
+			return CheckSkip, "Network config is not loaded"
+		}
+		validatorAddress := w.Address()
+		validatorAddress.SetTestnetOnly(treasuryAddress.IsTestnetOnly())
+		loanAddress := loadLoanAddress(validatorAddress, treasuryAddress, nextRoundSince, api, ctx, mainchainInfo)
+		return CheckPass, fmt.Sprintf("Loan of next round is %v", loanAddress.String())
+	})
+
+	r.check("Balance", func() (CheckStatus, string) {
+		if w == nil {
+			return CheckSkip, "Wallet is not loaded"
+		}
+		value := loadRequestValue(config.Borrow, api, ctx, mainchainInfo, treasuryAddress)
+		twoRounds := new(big.Int).Mul(value, big.NewInt(2))
+		balance := loadBalance(w, mainchainInfo)
+		detail := fmt.Sprintf("Balance is %v TON, each request needs %v TON",
+			tlb.FromNanoTON(balance).String(), tlb.FromNanoTON(value).String())
+		if balance.Cmp(value) != 1 {
+			return CheckFail, detail
+		}
+		if balance.Cmp(twoRounds) != 1 {
+			return CheckWarn, detail + ", not enough for the following round"
+		}
+		return CheckPass, detail
+	})
+
+	r.check("Public IP", func() (CheckStatus, string) {
+		if engineConfig == nil {
+			return CheckSkip, "Engine config is not loaded"
+		}
+		return checkPublicIp(engineConfig)
+	})
+
+	r.check("ADNL port", func() (CheckStatus, string) {
+		if engineConfig == nil {
+			return CheckSkip, "Engine config is not loaded"
+		}
+		return checkAdnlPort(engineConfig)
+	})
+
+	r.check("Liteserver port", func() (CheckStatus, string) {
+		if config.ValidatorEngine.LiteserverPort == 0 {
+			return CheckWarn, "liteserver_port is not configured"
+		}
+		if engineConfig == nil {
+			return CheckSkip, "Engine config is not loaded"
+		}
+		if len(engineConfig.Addrs) == 0 {
+			return CheckFail, "The engine has no public address"
+		}
+		return checkTcpPort("Liteserver", FormatIp(engineConfig.Addrs[0].Ip), config.ValidatorEngine.LiteserverPort)
+	})
+
+	r.check("Control port", func() (CheckStatus, string) {
+		return checkTcpPort("Control", config.ValidatorEngine.Ip, config.ValidatorEngine.ControlPort)
+	})
+
+	return r
+}
+
+// checkLocalLiteserver compares the last masterchain block of the local liteserver with the network.
+func checkLocalLiteserver(config ValidatorEngine, mainchainInfo *ton.BlockIDExt) (CheckStatus, string) {
+	if config.LiteserverKey == "" || config.LiteserverPort == 0 {
+		return CheckWarn, "liteserver_key and liteserver_port are not configured"
+	}
+	key, err := readKeyFile(config.LiteserverKey)
+	if err != nil {
+		panic(fmt.Sprintf("Error in reading liteserver key: %v", err))
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	pool := liteclient.NewConnectionPool()
+	defer pool.Stop()
+	err = pool.AddConnection(ctx, fmt.Sprintf("%v:%v", config.Ip, config.LiteserverPort),
+		base64.StdEncoding.EncodeToString(key))
+	if err != nil {
+		return CheckFail, fmt.Sprintf("Can't connect to the local liteserver: %v", err)
+	}
+	local, err := ton.NewAPIClient(pool).GetMasterchainInfo(ctx)
+	if err != nil {
+		return CheckFail, fmt.Sprintf("Can't get masterchain info of the local liteserver: %v", err)
+	}
+	behind := int64(mainchainInfo.SeqNo) - int64(local.SeqNo)
+	if behind > 10 {
+		return CheckFail, fmt.Sprintf("Local liteserver is %v blocks behind the network", behind)
+	}
+	return CheckPass, fmt.Sprintf("Local liteserver is at block %v", local.SeqNo)
+}
+
+func checkWalletDeployed(api ton.APIClientWrapped, ctx context.Context, mainchainInfo *ton.BlockIDExt,
+	w *wallet.Wallet, version wallet.Version) (CheckStatus, string) {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	account, err := api.GetAccount(ctx, mainchainInfo, w.Address())
+	if err != nil {
+		panic(fmt.Sprintf("Error in getting wallet account: %v", err))
+	}
+	if !account.IsActive {
+		return CheckFail, fmt.Sprintf("Wallet %v is not deployed", w.Address().String())
+	}
+	deployed := wallet.GetWalletVersion(account)
+	if deployed != version {
+		return CheckFail, fmt.Sprintf("Wallet %v is deployed as %v, but %v is configured",
+			w.Address().String(), deployed, version)
+	}
+	return CheckPass, fmt.Sprintf("Wallet %v is deployed as %v", w.Address().String(), version)
+}
+
+// checkPublicIp makes sure the public IP of the engine is assigned to this host.
+func checkPublicIp(engineConfig *EngineConfig) (CheckStatus, string) {
+	if len(engineConfig.Addrs) == 0 {
+		return CheckFail, "The engine has no public address"
+	}
+	ip := FormatIp(engineConfig.Addrs[0].Ip)
+
+	interfaceAddrs, err := net.InterfaceAddrs()
+	if err != nil {
+		panic(fmt.Sprintf("Error in listing network interfaces: %v", err))
+	}
+	for _, a := range interfaceAddrs {
+		if n, ok := a.(*net.IPNet); ok && n.IP.String() == ip {
+			return CheckPass, fmt.Sprintf("Public IP %v is assigned to this host", ip)
+		}
+	}
+	return CheckWarn, fmt.Sprintf("Public IP %v is not assigned to this host, make sure its ports are forwarded", ip)
+}
+
+// checkAdnlPort makes sure the engine listens on its ADNL UDP port, which can't be dialed like a TCP port. When the
+// port can be bound, nothing is listening on it.
+func checkAdnlPort(engineConfig *EngineConfig) (CheckStatus, string) {
+	if len(engineConfig.Addrs) == 0 {
+		return CheckFail, "The engine has no public address"
+	}
+	port := engineConfig.Addrs[0].Port
+
+	conn, err := net.ListenPacket("udp", fmt.Sprintf(":%v", port))
+	if err == nil {
+		conn.Close()
+		return CheckFail, fmt.Sprintf("Nothing listens on ADNL UDP port %v of this host", port)
+	}
+	if !errors.Is(err, syscall.EADDRINUSE) {
+		return CheckWarn, fmt.Sprintf("Can't check ADNL UDP port %v: %v", port, err)
+	}
+	return CheckPass, fmt.Sprintf("Engine listens on ADNL UDP port %v", port)
+}
+
+// checkTcpPort makes sure a TCP port of the engine is reachable on an IP.
+func checkTcpPort(name string, ip string, port uint16) (CheckStatus, string) {
+	conn, err := net.DialTimeout("tcp", fmt.Sprintf("%v:%v", ip, port), 5*time.Second)
+	if err != nil {
+		return CheckFail, fmt.Sprintf("%v port %v is not reachable on %v: %v", name, port, ip, err)
+	}
+	conn.Close()
+	return CheckPass, fmt.Sprintf("%v port %v is reachable on %v", name, port, ip)
+}
+
+// Doctor prints the readiness of the borrower for requesting a loan, and returns an error when it's not ready.
+func Doctor() error {
+	var ready bool
+	err := runCommand(func() {
+		config := loadConfig()
+
+		api, ctx := loadApi(config)
+
+		engine := NewValidatorEngine(config.ValidatorEngine)
+		defer engine.Close()
+
+		treasuryAddress := address.MustParseAddr(config.Treasury)
+
+		mainchainInfo := loadMainchainInfo(api, ctx)
+
+		readiness := checkReadiness(config, api, ctx, engine, mainchainInfo, treasuryAddress)
+		readiness.Print(os.Stdout)
+		ready = readiness.Ready()
+	})
+	if err == nil && !ready {
+		err = fmt.Errorf("not ready to request a loan")
+	}
+	return err
+}
@@ -0,0 +1,360 @@
+package borrower
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/xssnick/tonutils-go/tlb"
+	"github.com/xssnick/tonutils-go/ton/wallet"
+	"github.com/xssnick/tonutils-go/tvm/cell"
+)
+
+// SignRequest is sent to a remote signer to sign the body of an external message of the wallet. The signer checks
+// that the hash matches the body and that the summary matches the messages in the body, then applies its policy.
+type SignRequest struct {
+	PublicKey string            `json:"public_key"`
+	Version   string            `json:"version"`
+	Subwallet uint32            `json:"subwallet"`
+	Hash      string            `json:"hash"`
+	Body      []byte            `json:"body"`
+	Summary   []*MessageSummary `json:"summary"`
+}
+
+// SignResponse has the ed25519 signature of the hash of the body, or the reason the signer refused to sign it.
+type SignResponse struct {
+	Signature []byte `json:"signature,omitempty"`
+	Error     string `json:"error,omitempty"`
+}
+
+// MessageSummary is the human-readable description of one internal message sent by the wallet.
+type MessageSummary struct {
+	Destination string `json:"destination"`
+	Value       string `json:"value"`
+	Mode        uint8  `json:"mode"`
+	Bounce      bool   `json:"bounce"`
+	Op          uint32 `json:"op"`
+	OpName      string `json:"op_name"`
+}
+
+func (s *MessageSummary) String() string {
+	return fmt.Sprintf("%v with %v TON to %v", s.OpName, s.Value, s.Destination)
+}
+
+// WalletBody is the unsigned body of an external message of a wallet.
+type WalletBody struct {
+	// Subwallet is the subwallet id, or the wallet id of v5 wallets
+	Subwallet  uint32
+	ValidUntil uint32
+	// Seqno is the seqno, or the query id of highload wallets
+	Seqno    uint64
+	Messages []*tlb.InternalMessage
+	Modes    []uint8
+}
+
+func parseWalletBody(body *cell.Cell, version wallet.Version) (*WalletBody, error) {
+	s := body.BeginParse()
+	b := &WalletBody{}
+	var err error
+	switch version {
+	case wallet.V3R2, wallet.V4R2:
+		err = b.parseRegular(s, version)
+	case wallet.V5R1Final:
+		err = b.parseV5(s)
+	case wallet.HighloadV2R2:
+		err = b.parseHighloadV2(s)
+	case wallet.HighloadV3:
+		err = b.parseHighloadV3(s)
+	default:
+		err = fmt.Errorf("unsupported wallet version %v", version)
+	}
+	if err == nil && (s.BitsLeft() != 0 || s.RefsNum() != 0) {
+		err = errors.New("unexpected data after messages")
+	}
+	if err != nil {
+		return nil, fmt.Errorf("invalid wallet message body: %w", err)
+	}
+	return b, nil
+}
+
+// parseRegular parses subwallet_id valid_until seqno, and op for v4, followed by pairs of mode and ^message.
+func (b *WalletBody) parseRegular(s *cell.Slice, version wallet.Version) error {
+	var err error
+	b.Subwallet, err = loadUint32(s)
+	if err == nil {
+		b.ValidUntil, err = loadUint32(s)
+	}
+	if err == nil {
+		b.Seqno, err = s.LoadUInt(32)
+	}
+	if err == nil && version == wallet.V4R2 {
+		var op int64
+		op, err = s.LoadInt(8)
+		if err == nil && op != 0 {
+			err = fmt.Errorf("unsupported wallet op %v", op)
+		}
+	}
+	for err == nil && s.RefsNum() > 0 {
+		err = b.addMessage(s)
+	}
+	return err
+}
+
+// parseV5 parses #7369676e wallet_id valid_until seqno, followed by an optional ^OutList and no extended actions.
+func (b *WalletBody) parseV5(s *cell.Slice) error {
+	op, err := loadUint32(s)
+	if err == nil && op != 0x7369676e {
+		err = fmt.Errorf("unexpected op 0x%08x", op)
+	}
+	if err == nil {
+		b.Subwallet, err = loadUint32(s)
+	}
+	if err == nil {
+		b.ValidUntil, err = loadUint32(s)
+	}
+	if err == nil {
+		b.Seqno, err = s.LoadUInt(32)
+	}
+	var hasActions, hasExtended bool
+	if err == nil {
+		hasActions, err = s.LoadBoolBit()
+	}
+	if err == nil && hasActions {
+		var list *cell.Cell
+		list, err = s.LoadRefCell()
+		if err == nil {
+			err = b.addOutList(list)
+		}
+	}
+	if err == nil {
+		hasExtended, err = s.LoadBoolBit()
+	}
+	if err == nil && hasExtended {
+		err = errors.New("extended actions are not supported")
+	}
+	return err
+}
+
+// addOutList adds the messages of an OutList, which keeps the last action in its root.
+func (b *WalletBody) addOutList(list *cell.Cell) error {
+	s := list.BeginParse()
+	if s.BitsLeft() == 0 && s.RefsNum() == 0 {
+		return nil
+	}
+	prev, err := s.LoadRefCell()
+	if err != nil {
+		return err
+	}
+	err = b.addOutList(prev)
+	if err != nil {
+		return err
+	}
+	prefix, err := loadUint32(s)
+	if err == nil && prefix != 0x0ec3c86d {
+		err = fmt.Errorf("unsupported action 0x%08x", prefix)
+	}
+	if err == nil {
+		err = b.addMessage(s)
+	}
+	if err == nil && (s.BitsLeft() != 0 || s.RefsNum() != 0) {
+		err = errors.New("unexpected data after action")
+	}
+	return err
+}
+
+// parseHighloadV2 parses subwallet_id query_id, where query_id has valid_until in its high 32 bits, followed by a
+// dictionary of mode and ^message.
+func (b *WalletBody) parseHighloadV2(s *cell.Slice) error {
+	var queryId uint64
+	var messages *cell.Dictionary
+	var err error
+	b.Subwallet, err = loadUint32(s)
+	if err == nil {
+		queryId, err = s.LoadUInt(64)
+	}
+	if err == nil {
+		b.ValidUntil = uint32(queryId >> 32)
+		b.Seqno = queryId
+		messages, err = s.LoadDict(16)
+	}
+	if err == nil && messages != nil {
+		for i := 0; err == nil && i < messages.Size(); i++ {
+			var m *cell.Slice
+			m, err = messages.LoadValueByIntKey(big.NewInt(int64(i)))
+			if err == nil {
+				err = b.addMessage(m)
+			}
+		}
+	}
+	return err
+}
+
+// parseHighloadV3 parses subwallet_id ^message mode query_id created_at timeout.
+func (b *WalletBody) parseHighloadV3(s *cell.Slice) error {
+	var message *cell.Cell
+	var mode, createdAt, timeout uint64
+	var err error
+	b.Subwallet, err = loadUint32(s)
+	if err == nil {
+		message, err = s.LoadRefCell()
+	}
+	if err == nil {
+		mode, err = s.LoadUInt(8)
+	}
+	if err == nil {
+		b.Seqno, err = s.LoadUInt(23)
+	}
+	if err == nil {
+		createdAt, err = s.LoadUInt(64)
+	}
+	if err == nil {
+		timeout, err = s.LoadUInt(22)
+	}
+	if err == nil {
+		b.ValidUntil = uint32(createdAt + timeout)
+		m := &tlb.InternalMessage{}
+		err = tlb.LoadFromCell(m, message.BeginParse())
+		b.Messages = append(b.Messages, m)
+		b.Modes = append(b.Modes, uint8(mode))
+	}
+	return err
+}
+
+// addMessage loads a mode and a ^message.
+func (b *WalletBody) addMessage(s *cell.Slice) error {
+	mode, err := s.LoadUInt(8)
+	if err != nil {
+		return err
+	}
+	ref, err := s.LoadRefCell()
+	if err != nil {
+		return err
+	}
+	m := &tlb.InternalMessage{}
+	err = tlb.LoadFromCell(m, ref.BeginParse())
+	if err != nil {
+		return err
+	}
+	b.Messages = append(b.Messages, m)
+	b.Modes = append(b.Modes, uint8(mode))
+	return nil
+}
+
+func loadUint32(s *cell.Slice) (uint32, error) {
+	v, err := s.LoadUInt(32)
+	return uint32(v), err
+}
+
+func (b *WalletBody) Summary() []*MessageSummary {
+	summary := []*MessageSummary{}
+	for i, m := range b.Messages {
+		s := &MessageSummary{
+			Destination: m.DstAddr.String(),
+			Value:       m.Amount.String(),
+			Mode:        b.Modes[i],
+			Bounce:      m.Bounce,
+			OpName:      "transfer",
+		}
+		if m.Body != nil {
+			op, err := m.Body.BeginParse().LoadUInt(32)
+			if err == nil {
+				s.Op = uint32(op)
+				s.OpName = OpName(s.Op)
+			}
+		}
+		summary = append(summary, s)
+	}
+	return summary
+}
+
+// remoteSigner returns a signer of wallet messages which asks the signer service configured for the wallet.
+func remoteSigner(config Wallet, publicKey ed25519.PublicKey, version wallet.Version) wallet.Signer {
+	client, url := newSignerClient(config.Signer)
+	return func(ctx context.Context, toSign *cell.Cell, subwallet uint32) ([]byte, error) {
+		body, err := parseWalletBody(toSign, version)
+		if err != nil {
+			return nil, err
+		}
+		request := &SignRequest{
+			PublicKey: hex.EncodeToString(publicKey),
+			Version:   config.Version,
+			Subwallet: subwallet,
+			Hash:      hex.EncodeToString(toSign.Hash()),
+			Body:      toSign.ToBOC(),
+			Summary:   body.Summary(),
+		}
+		payload, err := json.Marshal(request)
+		if err != nil {
+			return nil, err
+		}
+
+		ctx, cancel := context.WithTimeout(ctx, 30*time.Second)
+		defer cancel()
+		httpRequest, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(payload))
+		if err != nil {
+			return nil, err
+		}
+		httpRequest.Header.Set("Content-Type", "application/json")
+		if config.SignerToken != "" {
+			httpRequest.Header.Set("Authorization", "Bearer "+config.SignerToken)
+		}
+		httpResponse, err := client.Do(httpRequest)
+		if err != nil {
+			return nil, fmt.Errorf("failed to reach signer: %w", err)
+		}
+		defer httpResponse.Body.Close()
+
+		response := &SignResponse{}
+		err = json.NewDecoder(httpResponse.Body).Decode(response)
+		if err != nil {
+			return nil, fmt.Errorf("invalid response of signer with status %v: %w", httpResponse.Status, err)
+		}
+		if response.Error != "" {
+			return nil, fmt.Errorf("signer refused: %v", response.Error)
+		}
+		if !ed25519.Verify(publicKey, toSign.Hash(), response.Signature) {
+			return nil, errors.New("invalid signature from signer")
+		}
+		return response.Signature, nil
+	}
+}
+
+// newSignerClient returns an HTTP client and the URL to post sign requests to. Addresses starting with unix: are
+// paths of Unix sockets.
+func newSignerClient(signer string) (*http.Client, string) {
+	if path, found := strings.CutPrefix(signer, "unix:"); found {
+		transport := &http.Transport{
+			DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+				return (&net.Dialer{}).DialContext(ctx, "unix", path)
+			},
+		}
+		return &http.Client{Transport: transport}, "http://signer/sign"
+	}
+	return &http.Client{}, strings.TrimSuffix(signer, "/") + "/sign"
+}
+
+func loadRemoteWallet(config Wallet, api wallet.TonAPI, versionConfig wallet.VersionConfig) *wallet.Wallet {
+	if config.Signer == "" {
+		panic("Error, signer of remote wallet is not configured")
+	}
+	publicKey, err := hex.DecodeString(config.PublicKey)
+	if err != nil || len(publicKey) != ed25519.PublicKeySize {
+		panic(fmt.Sprintf("Error, invalid public_key of remote wallet, expected 64 hex characters but got: %v",
+			config.PublicKey))
+	}
+	signer := remoteSigner(config, publicKey, parseWalletVersion(config))
+	w, err := wallet.FromSigner(api, publicKey, versionConfig, signer)
+	if err != nil {
+		panic(fmt.Sprintf("Error in loading wallet: %v", err))
+	}
+	return w
+}
@@ -0,0 +1,293 @@
+package borrower
+
+import (
+	"context"
+	"fmt"
+	"math/big"
+	"os"
+	"sort"
+	"text/tabwriter"
+	"time"
+
+	"github.com/xssnick/tonutils-go/address"
+	"github.com/xssnick/tonutils-go/tlb"
+	"github.com/xssnick/tonutils-go/ton"
+	"github.com/xssnick/tonutils-go/tvm/cell"
+)
+
+// PastElection is an entry of past_elections of the elector, which keeps the elections whose stakes are still
+// frozen. Bonuses grow with the fees and minted TON of the round until its validation ends.
+type PastElection struct {
+	ElectionId uint32
+	UnfreezeAt uint32
+	StakeHeld  uint32
+	TotalStake *big.Int
+	Bonuses    *big.Int
+	// Stakes is the frozen stakes of the elected validators, from the highest to the lowest.
+	Stakes []*big.Int
+}
+
+// RewardEstimate is the expected reward of a loan request with the borrow config, from the bonuses per staked TON of
+// past elections. The effective stake is what the elector is expected to use of the loan and stake, when it's
+// elected with the stakes of the latest election, and the validator gets validator_reward_share/255 of the reward.
+// MaxMinPayment is the highest min payment that still leaves a profit after the request loan fee.
+type RewardEstimate struct {
+	Elections      []*PastElection
+	Extrapolated   bool
+	RewardRate     *big.Rat
+	Loan           *big.Int
+	Stake          *big.Int
+	MaxFactor      *MaxFactorAdvice
+	EffectiveStake *big.Int
+	Reward         *big.Int
+	Share          *big.Int
+	RequestLoanFee *big.Int
+	MaxMinPayment  *big.Int
+}
+
+func (e *PastElection) validationEnd() uint32 {
+	return e.UnfreezeAt - e.StakeHeld
+}
+
+func loadElectorAddress(api ton.APIClientWrapped, ctx context.Context,
+	mainchainInfo *ton.BlockIDExt) *address.Address {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	blockchainConfig, err := api.GetBlockchainConfig(ctx, mainchainInfo, ConfigElector)
+	if err != nil {
+		panic(fmt.Sprintf("Error in getting blockchain config: %v", err))
+	}
+
+	return GetElectorAddress(blockchainConfig.Get(ConfigElector))
+}
+
+func loadPastElections(api ton.APIClientWrapped, ctx context.Context,
+	mainchainInfo *ton.BlockIDExt) []*PastElection {
+	electorAddress := loadElectorAddress(api, ctx, mainchainInfo)
+
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	result, err := api.RunGetMethod(ctx, mainchainInfo, electorAddress, "past_elections")
+	if err != nil {
+		panic(fmt.Sprintf("Error in getting past elections: %v", err))
+	}
+
+	return parsePastElections(result.AsTuple()[0])
+}
+
+// parsePastElections parses the list returned by past_elections, where each node is a pair of an entry and the rest
+// of the list, and each entry is [election_id, unfreeze_at, stake_held, vset_hash, frozen_dict, total_stake,
+// bonuses, complaints].
+func parsePastElections(list any) []*PastElection {
+	elections := []*PastElection{}
+	for list != nil {
+		node, ok := list.([]any)
+		if !ok || len(node) != 2 {
+			panic("Error, unexpected list in past elections")
+		}
+		entry, ok := node[0].([]any)
+		if !ok || len(entry) < 7 {
+			panic("Error, unexpected entry in past elections")
+		}
+		e := &PastElection{
+			ElectionId: uint32(tupleInt(entry, 0).Uint64()),
+			UnfreezeAt: uint32(tupleInt(entry, 1).Uint64()),
+			StakeHeld:  uint32(tupleInt(entry, 2).Uint64()),
+			TotalStake: tupleInt(entry, 5),
+			Bonuses:    tupleInt(entry, 6),
+		}
+		if frozen, ok := entry[4].(*cell.Cell); ok {
+			e.Stakes = loadFrozenStakes(frozen.AsDict(256))
+		}
+		elections = append(elections, e)
+		list = node[1]
+	}
+	return elections
+}
+
+func tupleInt(tuple []any, index int) *big.Int {
+	i, ok := tuple[index].(*big.Int)
+	if !ok {
+		panic(fmt.Sprintf("Error, expected an integer at %v in past elections", index))
+	}
+	return i
+}
+
+// loadFrozenStakes returns the stakes in the frozen dictionary of an election from the highest to the lowest, where
+// each value is addr:bits256 weight:uint64 stake:Grams banned:Bool.
+func loadFrozenStakes(frozen *cell.Dictionary) []*big.Int {
+	stakes := []*big.Int{}
+	for _, kv := range frozen.All() {
+		s := kv.Value.BeginParse()
+		s.MustLoadSlice(256)
+		s.MustLoadUInt(64)
+		stakes = append(stakes, s.MustLoadBigCoins())
+	}
+	sort.Slice(stakes, func(i, j int) bool {
+		return stakes[i].Cmp(stakes[j]) > 0
+	})
+	return stakes
+}
+
+// loadRewardRate returns the bonuses per staked nanoTON over a round. Elections whose validation has ended are used
+// when there are any, and otherwise the bonuses of ongoing rounds are extrapolated to their full length.
+func loadRewardRate(elections []*PastElection, now uint32) (*big.Rat, []*PastElection, bool) {
+	used := []*PastElection{}
+	for _, e := range elections {
+		if e.validationEnd() <= now && e.TotalStake.Sign() > 0 {
+			used = append(used, e)
+		}
+	}
+	extrapolated := false
+	if len(used) == 0 {
+		extrapolated = true
+		for _, e := range elections {
+			if e.ElectionId < now && e.TotalStake.Sign() > 0 {
+				used = append(used, e)
+			}
+		}
+	}
+	if len(used) == 0 {
+		panic("Error, there are no past elections to estimate the reward")
+	}
+
+	rate := new(big.Rat)
+	for _, e := range used {
+		r := new(big.Rat).SetFrac(e.Bonuses, e.TotalStake)
+		if extrapolated {
+			r.Mul(r, big.NewRat(int64(e.validationEnd()-e.ElectionId), int64(now-e.ElectionId)))
+		}
+		rate.Add(rate, r)
+	}
+	rate.Quo(rate, big.NewRat(int64(len(used)), 1))
+	return rate, used, extrapolated
+}
+
+// estimateReward returns the expected reward of a loan request with the borrow config in the next round.
+func estimateReward(config *Config, api ton.APIClientWrapped, ctx context.Context, mainchainInfo *ton.BlockIDExt,
+	treasuryAddress *address.Address) *RewardEstimate {
+	_, minStake, _, _, _ := loadBlockchainConfig(api, ctx, mainchainInfo)
+	stake, loan, _, maxFactor, validatorRewardShare := loadBorrowConfig(config.Borrow, minStake)
+	requestLoanFee :=
+		getRequestLoanFee(api, ctx, mainchainInfo, treasuryAddress, loadMaxRequestLoanFee(config.Borrow))
+
+	elections := loadPastElections(api, ctx, mainchainInfo)
+	rate, used, extrapolated := loadRewardRate(elections, uint32(time.Now().Unix()))
+
+	e := &RewardEstimate{
+		Elections:      used,
+		Extrapolated:   extrapolated,
+		RewardRate:     rate,
+		Loan:           loan,
+		Stake:          stake,
+		EffectiveStake: new(big.Int).Add(loan, stake),
+		RequestLoanFee: requestLoanFee,
+	}
+
+	var latest *PastElection
+	for _, p := range elections {
+		if len(p.Stakes) > 0 && (latest == nil || p.ElectionId > latest.ElectionId) {
+			latest = p
+		}
+	}
+	if latest != nil {
+		limits := loadElectionLimits(api, ctx, mainchainInfo)
+		e.MaxFactor = adviseMaxFactor(latest.Stakes, e.EffectiveStake, maxFactor, limits)
+		e.EffectiveStake = e.MaxFactor.EffectiveStake
+	}
+
+	reward := new(big.Rat).Mul(rate, new(big.Rat).SetInt(e.EffectiveStake))
+	e.Reward = new(big.Int).Quo(reward.Num(), reward.Denom())
+	e.Share = new(big.Int).Mul(e.Reward, big.NewInt(int64(validatorRewardShare)))
+	e.Share.Quo(e.Share, big.NewInt(255))
+	e.MaxMinPayment = new(big.Int).Sub(e.Share, requestLoanFee)
+	if e.MaxMinPayment.Sign() < 0 {
+		e.MaxMinPayment.SetInt64(0)
+	}
+	return e
+}
+
+// competitiveMinPayment returns the lowest min payment whose rounded RoI reaches the cut-off RoI, rounding up to
+// 2^30 nanoTON like the treasury rounds it down.
+func competitiveMinPayment(cutoffRoi float64, loan *big.Int) *big.Int {
+	l := new(big.Int).Rsh(loan, loanRoundingBits)
+	if l.Sign() == 0 {
+		l.SetInt64(1)
+	}
+	l.Lsh(l, loanRoundingBits)
+	p := new(big.Rat).SetFloat64(cutoffRoi)
+	if p == nil {
+		panic(fmt.Sprintf("Error, invalid cut-off RoI: %v", cutoffRoi))
+	}
+	p.Mul(p, new(big.Rat).SetInt(l))
+	p.Quo(p, new(big.Rat).SetInt(new(big.Int).Lsh(big.NewInt(1), minPaymentRoundingBits)))
+	units := new(big.Int).Quo(p.Num(), p.Denom())
+	if new(big.Rat).SetInt(units).Cmp(p) < 0 {
+		units.Add(units, big.NewInt(1))
+	}
+	return units.Lsh(units, minPaymentRoundingBits)
+}
+
+// Recommend prints the expected reward of a loan request with the borrow config, the highest rational min payment,
+// the max factor that avoids clipping the stake, and the min payment that reaches the projected cut-off RoI of the
+// indexed history.
+func Recommend() error {
+	return runCommand(func() {
+		config := loadConfig()
+
+		api, ctx := loadApi(config)
+
+		treasuryAddress := address.MustParseAddr(config.Treasury)
+
+		mainchainInfo := loadMainchainInfo(api, ctx)
+
+		e := estimateReward(config, api, ctx, mainchainInfo, treasuryAddress)
+
+		rate, _ := e.RewardRate.Float64()
+		basedOn := fmt.Sprintf("%v finished rounds", len(e.Elections))
+		if e.Extrapolated {
+			basedOn = fmt.Sprintf("%v ongoing rounds, extrapolated", len(e.Elections))
+		}
+
+		tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintf(tw, "Reward per round:\t%.6f%% of stake, from %v\n", rate*100, basedOn)
+		fmt.Fprintf(tw, "Stake:\t%v TON loan and %v TON own stake\n", tlb.FromNanoTON(e.Loan).String(),
+			tlb.FromNanoTON(e.Stake).String())
+		fmt.Fprintf(tw, "Effective stake:\t%v TON\n", tlb.FromNanoTON(e.EffectiveStake).String())
+		fmt.Fprintf(tw, "Expected reward:\t%v TON\n", tlb.FromNanoTON(e.Reward).String())
+		fmt.Fprintf(tw, "Validator share:\t%v TON at %v\n", tlb.FromNanoTON(e.Share).String(),
+			formatShare(&config.Borrow.ValidatorRewardShare))
+		fmt.Fprintf(tw, "Request loan fee:\t%v TON\n", tlb.FromNanoTON(e.RequestLoanFee).String())
+		fmt.Fprintf(tw, "Max min payment:\t%v TON\n", tlb.FromNanoTON(e.MaxMinPayment).String())
+		tw.Flush()
+		fmt.Println()
+
+		if e.MaxFactor != nil {
+			printMaxFactorAdvice(e.MaxFactor)
+			fmt.Println()
+		}
+
+		market := loadMarket(loadHistory(config), time.Unix(0, 0), time.Unix(1<<32, 0))
+		trend := loadMarketTrend(market)
+		cutoffRoi := trend.NextCutoffRoi
+		if cutoffRoi == nil && len(market) > 0 {
+			cutoffRoi = market[len(market)-1].CutoffRoiAverage
+		}
+		if cutoffRoi == nil {
+			fmt.Println("Run borrower index to recommend a min payment from the competition of past rounds")
+			return
+		}
+
+		competitive := competitiveMinPayment(*cutoffRoi, e.Loan)
+		fmt.Printf("Projected cut-off RoI is %v, reached with a min payment of %v TON\n", formatRoi(cutoffRoi),
+			tlb.FromNanoTON(competitive).String())
+		if competitive.Cmp(e.MaxMinPayment) > 0 {
+			fmt.Printf("⚠️  Reaching the cut-off costs more than the max min payment, so a profitable loan request is " +
+				"likely rejected\n")
+			return
+		}
+		fmt.Printf("Recommended min_payment: %v TON\n", tlb.FromNanoTON(competitive).String())
+	})
+}
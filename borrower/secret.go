@@ -0,0 +1,250 @@
+package borrower
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+
+	"golang.org/x/crypto/scrypt"
+)
+
+const (
+	// PassphraseCredential is the name of the systemd credential with the passphrase, as in
+	// LoadCredential=wallet-passphrase:/etc/borrower/passphrase
+	PassphraseCredential = "wallet-passphrase"
+	// PassphraseEnv is the environment variable with the passphrase.
+	PassphraseEnv = "BORROWER_WALLET_PASSPHRASE"
+	// PassphraseFdEnv is the environment variable with the number of an open file descriptor to read the passphrase from.
+	PassphraseFdEnv = "BORROWER_WALLET_PASSPHRASE_FD"
+)
+
+// EncryptedSecret is the format of an encrypted wallet secret file. The secret is encrypted with AES-256-GCM, using
+// a key derived from the passphrase with scrypt.
+type EncryptedSecret struct {
+	Kdf    string `json:"kdf"`
+	N      int    `json:"n"`
+	R      int    `json:"r"`
+	P      int    `json:"p"`
+	Salt   []byte `json:"salt"`
+	Cipher string `json:"cipher"`
+	Nonce  []byte `json:"nonce"`
+	Data   []byte `json:"data"`
+}
+
+const (
+	scryptN = 1 << 17
+	scryptR = 8
+	scryptP = 1
+)
+
+var errNoPassphrase = fmt.Errorf("no passphrase, set credential %v, or %v, or %v",
+	PassphraseCredential, PassphraseEnv, PassphraseFdEnv)
+
+// passphrase is cached, since a file descriptor can be read only once.
+var passphrase struct {
+	sync.Mutex
+	value []byte
+}
+
+func encryptSecret(secret []byte, passphrase []byte) ([]byte, error) {
+	e := &EncryptedSecret{Kdf: "scrypt", N: scryptN, R: scryptR, P: scryptP, Cipher: "aes-256-gcm"}
+	e.Salt = make([]byte, 32)
+	_, err := rand.Read(e.Salt)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := newSecretCipher(e, passphrase)
+	if err != nil {
+		return nil, err
+	}
+	e.Nonce = make([]byte, aead.NonceSize())
+	_, err = rand.Read(e.Nonce)
+	if err != nil {
+		return nil, err
+	}
+	e.Data = aead.Seal(nil, e.Nonce, secret, nil)
+	return json.MarshalIndent(e, "", "  ")
+}
+
+func decryptSecret(contents []byte, passphrase []byte) ([]byte, error) {
+	e := &EncryptedSecret{}
+	err := json.Unmarshal(contents, e)
+	if err != nil {
+		return nil, fmt.Errorf("invalid encrypted secret: %w", err)
+	}
+	// Parameters above the ones encryptSecret writes are rejected, so a corrupted or tampered file can't make the
+	// key derivation allocate gigabytes.
+	if e.N > scryptN || e.R > scryptR || e.P > scryptP {
+		return nil, fmt.Errorf("scrypt parameters of encrypted secret are too high: n %v, r %v, p %v", e.N, e.R, e.P)
+	}
+	aead, err := newSecretCipher(e, passphrase)
+	if err != nil {
+		return nil, err
+	}
+	if len(e.Nonce) != aead.NonceSize() {
+		return nil, errors.New("invalid nonce of encrypted secret")
+	}
+	secret, err := aead.Open(nil, e.Nonce, e.Data, nil)
+	if err != nil {
+		return nil, errors.New("wrong passphrase or corrupted secret")
+	}
+	return secret, nil
+}
+
+func newSecretCipher(e *EncryptedSecret, passphrase []byte) (cipher.AEAD, error) {
+	if e.Kdf != "scrypt" || e.Cipher != "aes-256-gcm" {
+		return nil, fmt.Errorf("unsupported encryption %v with %v", e.Cipher, e.Kdf)
+	}
+	key, err := scrypt.Key(passphrase, e.Salt, e.N, e.R, e.P, 32)
+	if err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// loadPassphrase reads the passphrase of the wallet from a systemd credential, the environment, or a file
+// descriptor, in that order.
+func loadPassphrase() ([]byte, error) {
+	passphrase.Lock()
+	defer passphrase.Unlock()
+	if passphrase.value != nil {
+		return passphrase.value, nil
+	}
+
+	var value []byte
+	if dir := os.Getenv("CREDENTIALS_DIRECTORY"); dir != "" {
+		contents, err := os.ReadFile(filepath.Join(dir, PassphraseCredential))
+		if err == nil {
+			value = contents
+		} else if !errors.Is(err, os.ErrNotExist) {
+			return nil, fmt.Errorf("failed to read credential %v: %w", PassphraseCredential, err)
+		}
+	}
+	if value == nil {
+		if env, found := os.LookupEnv(PassphraseEnv); found {
+			value = []byte(env)
+		}
+	}
+	if value == nil {
+		if env := os.Getenv(PassphraseFdEnv); env != "" {
+			fd, err := strconv.Atoi(env)
+			if err != nil {
+				return nil, fmt.Errorf("invalid %v: %v", PassphraseFdEnv, env)
+			}
+			file := os.NewFile(uintptr(fd), "passphrase")
+			value, err = io.ReadAll(file)
+			file.Close()
+			if err != nil {
+				return nil, fmt.Errorf("failed to read passphrase from file descriptor %v: %w", fd, err)
+			}
+		}
+	}
+	if value == nil {
+		return nil, errNoPassphrase
+	}
+
+	value = bytes.TrimRight(value, "\r\n")
+	if len(value) == 0 {
+		return nil, errors.New("empty passphrase")
+	}
+	passphrase.value = value
+	return value, nil
+}
+
+// loadWalletSecret reads the secret file of the wallet, and decrypts it when it's encrypted.
+func loadWalletSecret(config Wallet) []byte {
+	contents, err := os.ReadFile(config.Path)
+	if err != nil {
+		panic(fmt.Sprintf("Error in reading wallet secret: %v", err))
+	}
+	if !config.Encrypted {
+		return contents
+	}
+
+	passphrase, err := loadPassphrase()
+	if err != nil {
+		panic(fmt.Sprintf("Error in reading wallet passphrase: %v", err))
+	}
+	secret, err := decryptSecret(contents, passphrase)
+	if err != nil {
+		panic(fmt.Sprintf("Error in decrypting wallet secret: %v", err))
+	}
+	return secret
+}
+
+// encryptWalletSecret encrypts a wallet secret with the passphrase from the environment, or from the terminal.
+func encryptWalletSecret(secret []byte) []byte {
+	passphrase, err := loadPassphrase()
+	if errors.Is(err, errNoPassphrase) {
+		passphrase, err = promptPassphrase()
+	}
+	if err != nil {
+		panic(fmt.Sprintf("Error in reading passphrase: %v", err))
+	}
+
+	encrypted, err := encryptSecret(secret, passphrase)
+	if err != nil {
+		panic(fmt.Sprintf("Error in encrypting wallet secret: %v", err))
+	}
+	_, err = decryptSecret(encrypted, passphrase)
+	if err != nil {
+		panic(fmt.Sprintf("Error in verifying encrypted wallet secret: %v", err))
+	}
+	return encrypted
+}
+
+// writeSecretFile writes a new secret file that only its owner can read, and never overwrites an existing one.
+func writeSecretFile(path string, contents []byte) {
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		panic(fmt.Sprintf("Error in creating %v: %v", path, err))
+	}
+	_, err = file.Write(contents)
+	if err == nil {
+		err = file.Sync()
+	}
+	file.Close()
+	if err != nil {
+		os.Remove(path)
+		panic(fmt.Sprintf("Error in writing %v: %v", path, err))
+	}
+}
+
+// WalletEncrypt encrypts the plaintext secret file of the wallet to out, leaving the plaintext file as it is.
+func WalletEncrypt(out string) error {
+	return runCommand(func() {
+		config := loadConfig()
+		if config.Wallet.Type == "remote" {
+			panic("Remote wallet has no secret file")
+		}
+		if config.Wallet.Encrypted {
+			panic(fmt.Sprintf("Wallet secret %v is already encrypted", config.Wallet.Path))
+		}
+		if out == "" {
+			out = config.Wallet.Path + ".enc"
+		}
+
+		secret := loadWalletSecret(config.Wallet)
+		w := walletFromSecret(nil, config.Wallet.Type, secret, loadVersionConfig(config.Wallet))
+		w = withSubwallet(w, config.Wallet)
+
+		writeSecretFile(out, encryptWalletSecret(secret))
+
+		fmt.Printf("Encrypted the secret of wallet %v to %v\n", w.Address().String(), out)
+		fmt.Printf("Set wallet.path to %v and wallet.encrypted to yes, then securely delete %v\n",
+			out, config.Wallet.Path)
+	})
+}
@@ -0,0 +1,257 @@
+package borrower
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"reflect"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/xssnick/tonutils-go/address"
+	"github.com/xssnick/tonutils-go/tlb"
+	"github.com/xssnick/tonutils-go/ton/wallet"
+	"github.com/xssnick/tonutils-go/tvm/cell"
+	"gopkg.in/yaml.v3"
+)
+
+var SignerConfigFile = "signer.yaml"
+
+// SignerConfig is the config of the reference signer, which keeps the secret of the wallet away from the borrower.
+type SignerConfig struct {
+	Listen string
+	Token  string
+	Wallet Wallet
+	Policy SignerPolicy
+}
+
+// SignerPolicy limits what the signer signs, so that a compromised borrower can't drain the wallet.
+type SignerPolicy struct {
+	Destinations []string
+	Ops          []string
+	MaxValue     string        `yaml:"max_value"`
+	MaxMessages  int           `yaml:"max_messages"`
+	MaxTtl       time.Duration `yaml:"max_ttl"`
+}
+
+type signer struct {
+	config       *SignerConfig
+	wallet       *wallet.Wallet
+	version      wallet.Version
+	bodyId       uint32
+	key          ed25519.PrivateKey
+	destinations []*address.Address
+	maxValue     tlb.Coins
+}
+
+func loadSignerConfig(path string) *SignerConfig {
+	contents, err := os.ReadFile(path)
+	if err != nil {
+		panic(fmt.Sprintf("Error in reading signer config: %v", err))
+	}
+	config := &SignerConfig{}
+	err = yaml.Unmarshal(contents, config)
+	if err != nil {
+		panic(fmt.Sprintf("Error in parsing signer config: %v", err))
+	}
+	return config
+}
+
+func newSigner(config *SignerConfig) *signer {
+	if config.Wallet.Type == "remote" {
+		panic("Error, the wallet of the signer can't be remote")
+	}
+	s := &signer{config: config, version: parseWalletVersion(config.Wallet)}
+	versionConfig := loadVersionConfig(config.Wallet)
+	s.wallet = walletFromSecret(nil, config.Wallet.Type, loadWalletSecret(config.Wallet), versionConfig)
+	s.wallet = withSubwallet(s.wallet, config.Wallet)
+	s.bodyId = walletBodyId(s.wallet, versionConfig)
+	s.key = s.wallet.PrivateKey()
+
+	if len(config.Policy.Destinations) == 0 {
+		panic("Error, no destinations are allowed in signer policy")
+	}
+	for _, d := range config.Policy.Destinations {
+		a, err := address.ParseAddr(d)
+		if err != nil {
+			panic(fmt.Sprintf("Error, invalid destination %v in signer policy: %v", d, err))
+		}
+		s.destinations = append(s.destinations, a)
+	}
+	if len(config.Policy.Ops) == 0 {
+		config.Policy.Ops = []string{OpName(LoanRequest)}
+	}
+	maxValue, err := tlb.FromTON(config.Policy.MaxValue)
+	if err != nil {
+		panic(fmt.Sprintf("Error, invalid max_value in signer policy: %v", err))
+	}
+	s.maxValue = maxValue
+	if config.Policy.MaxMessages == 0 {
+		config.Policy.MaxMessages = 1
+	}
+	if config.Policy.MaxTtl == 0 {
+		config.Policy.MaxTtl = 5 * time.Minute
+	}
+	return s
+}
+
+// sign checks a sign request against the policy, and returns the signature of the body.
+func (s *signer) sign(request *SignRequest) ([]byte, error) {
+	if request.PublicKey != hex.EncodeToString(s.wallet.PrivateKey().Public().(ed25519.PublicKey)) {
+		return nil, errors.New("unknown public key")
+	}
+	if request.Version != s.config.Wallet.Version {
+		return nil, fmt.Errorf("wallet version is %v, not %v", s.config.Wallet.Version, request.Version)
+	}
+	c, err := cell.FromBOC(request.Body)
+	if err != nil {
+		return nil, fmt.Errorf("invalid body: %w", err)
+	}
+	if hex.EncodeToString(c.Hash()) != request.Hash {
+		return nil, errors.New("hash doesn't match body")
+	}
+	body, err := parseWalletBody(c, s.version)
+	if err != nil {
+		return nil, err
+	}
+	if body.Subwallet != s.bodyId || request.Subwallet != s.wallet.GetSubwalletID() {
+		return nil, fmt.Errorf("unexpected subwallet %v", request.Subwallet)
+	}
+	if !reflect.DeepEqual(body.Summary(), request.Summary) {
+		return nil, errors.New("summary doesn't match body")
+	}
+
+	ttl := time.Until(time.Unix(int64(body.ValidUntil), 0))
+	if ttl <= 0 || ttl > s.config.Policy.MaxTtl {
+		return nil, fmt.Errorf("message is valid for %v, but max_ttl is %v", ttl.Round(time.Second),
+			s.config.Policy.MaxTtl)
+	}
+	if len(body.Messages) == 0 || len(body.Messages) > s.config.Policy.MaxMessages {
+		return nil, fmt.Errorf("%v messages, but max_messages is %v", len(body.Messages), s.config.Policy.MaxMessages)
+	}
+	for i, m := range body.Messages {
+		summary := request.Summary[i]
+		allowed := slices.ContainsFunc(s.destinations, func(a *address.Address) bool {
+			return a.StringRaw() == m.DstAddr.StringRaw()
+		})
+		if !allowed {
+			return nil, fmt.Errorf("destination %v is not allowed", summary.Destination)
+		}
+		if m.Amount.Nano().Cmp(s.maxValue.Nano()) > 0 {
+			return nil, fmt.Errorf("value %v TON is more than max_value %v TON", m.Amount.String(), s.maxValue.String())
+		}
+		if !slices.Contains(s.config.Policy.Ops, summary.OpName) {
+			return nil, fmt.Errorf("op %v is not allowed", summary.OpName)
+		}
+		if body.Modes[i]&128 != 0 || m.StateInit != nil {
+			return nil, fmt.Errorf("mode %v or state init is not allowed", body.Modes[i])
+		}
+	}
+
+	return c.Sign(s.key), nil
+}
+
+func (s *signer) handle(w http.ResponseWriter, r *http.Request) {
+	response := &SignResponse{}
+	status := http.StatusOK
+	defer func() {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		json.NewEncoder(w).Encode(response)
+	}()
+
+	if r.Method != http.MethodPost {
+		status = http.StatusMethodNotAllowed
+		response.Error = "method not allowed"
+		return
+	}
+	if s.config.Token != "" {
+		token, _ := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if subtle.ConstantTimeCompare([]byte(token), []byte(s.config.Token)) != 1 {
+			status = http.StatusUnauthorized
+			response.Error = "unauthorized"
+			return
+		}
+	}
+
+	request := &SignRequest{}
+	err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 64*1024)).Decode(request)
+	if err != nil {
+		status = http.StatusBadRequest
+		response.Error = fmt.Sprintf("invalid request: %v", err)
+		return
+	}
+
+	signature, err := s.sign(request)
+	if err != nil {
+		status = http.StatusForbidden
+		response.Error = err.Error()
+		log.Printf("⛔️ Refused to sign %v: %v", request.Summary, err)
+		return
+	}
+	response.Signature = signature
+	log.Printf("✍️  Signed %v", request.Summary)
+}
+
+// listen listens on a TCP address, or on a Unix socket when the address starts with unix:, which is only accessible
+// by the owner and group of the signer.
+func listen(addr string) (net.Listener, error) {
+	path, found := strings.CutPrefix(addr, "unix:")
+	if !found {
+		return net.Listen("tcp", addr)
+	}
+	err := os.Remove(path)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+	listener, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, err
+	}
+	err = os.Chmod(path, 0660)
+	if err != nil {
+		listener.Close()
+		return nil, err
+	}
+	return listener, nil
+}
+
+// ServeSigner runs the reference signer until stopped.
+func ServeSigner(configPath string, stop <-chan struct{}) error {
+	return runCommand(func() {
+		config := loadSignerConfig(configPath)
+
+		s := newSigner(config)
+
+		listener, err := listen(config.Listen)
+		if err != nil {
+			panic(fmt.Sprintf("Error in listening on %v: %v", config.Listen, err))
+		}
+
+		mux := http.NewServeMux()
+		mux.HandleFunc("/sign", s.handle)
+		server := &http.Server{Handler: mux}
+
+		go func() {
+			<-stop
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			server.Shutdown(ctx)
+		}()
+
+		log.Printf("🔏 Signing for wallet %v on %v", s.wallet.Address().String(), config.Listen)
+		err = server.Serve(listener)
+		if err != nil && !errors.Is(err, http.ErrServerClosed) {
+			panic(fmt.Sprintf("Error in serving signer: %v", err))
+		}
+	})
+}
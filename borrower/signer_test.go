@@ -0,0 +1,410 @@
+package borrower
+
+import (
+	"crypto/ed25519"
+	"encoding/hex"
+	"math/big"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/xssnick/tonutils-go/address"
+	"github.com/xssnick/tonutils-go/tlb"
+	"github.com/xssnick/tonutils-go/ton/wallet"
+	"github.com/xssnick/tonutils-go/tvm/cell"
+)
+
+var (
+	testTreasury = address.NewAddress(0, 0, make([]byte, 32))
+	testOther    = address.NewAddress(0, 0, append(make([]byte, 31), 1))
+)
+
+// testMessage is an internal message sent by the wallet in a test body.
+type testMessage struct {
+	destination *address.Address
+	value       string
+	op          uint32
+	mode        uint8
+	stateInit   bool
+}
+
+func loanMessage() testMessage {
+	return testMessage{destination: testTreasury, value: "101", op: LoanRequest, mode: 1}
+}
+
+func (m testMessage) cell(t *testing.T) *cell.Cell {
+	t.Helper()
+	message := &tlb.InternalMessage{
+		Bounce:  true,
+		DstAddr: m.destination,
+		Amount:  tlb.MustFromTON(m.value),
+	}
+	if m.op != 0 {
+		message.Body = cell.BeginCell().MustStoreUInt(uint64(m.op), 32).MustStoreUInt(0, 64).EndCell()
+	}
+	if m.stateInit {
+		message.StateInit = &tlb.StateInit{
+			Code: cell.BeginCell().MustStoreUInt(1, 8).EndCell(),
+			Data: cell.BeginCell().EndCell(),
+		}
+	}
+	c, err := tlb.ToCell(message)
+	if err != nil {
+		t.Fatalf("failed to build message: %v", err)
+	}
+	return c
+}
+
+// buildRegularBody builds the body of a v3r2 wallet, or of a v4r2 wallet with its op.
+func buildRegularBody(t *testing.T, version wallet.Version, subwallet uint32, validUntil uint32, seqno uint32,
+	messages ...testMessage) *cell.Cell {
+	b := cell.BeginCell().
+		MustStoreUInt(uint64(subwallet), 32).
+		MustStoreUInt(uint64(validUntil), 32).
+		MustStoreUInt(uint64(seqno), 32)
+	if version == wallet.V4R2 {
+		b.MustStoreInt(0, 8)
+	}
+	for _, m := range messages {
+		b.MustStoreUInt(uint64(m.mode), 8).MustStoreRef(m.cell(t))
+	}
+	return b.EndCell()
+}
+
+func buildV5Body(t *testing.T, walletId uint32, validUntil uint32, seqno uint32, messages ...testMessage) *cell.Cell {
+	list := cell.BeginCell().EndCell()
+	for _, m := range messages {
+		list = cell.BeginCell().
+			MustStoreRef(list).
+			MustStoreUInt(0x0ec3c86d, 32).
+			MustStoreUInt(uint64(m.mode), 8).
+			MustStoreRef(m.cell(t)).
+			EndCell()
+	}
+	b := cell.BeginCell().
+		MustStoreUInt(0x7369676e, 32).
+		MustStoreUInt(uint64(walletId), 32).
+		MustStoreUInt(uint64(validUntil), 32).
+		MustStoreUInt(uint64(seqno), 32)
+	if len(messages) > 0 {
+		b.MustStoreBoolBit(true).MustStoreRef(list)
+	} else {
+		b.MustStoreBoolBit(false)
+	}
+	return b.MustStoreBoolBit(false).EndCell()
+}
+
+func buildHighloadV2Body(t *testing.T, subwallet uint32, validUntil uint32, messages ...testMessage) *cell.Cell {
+	dict := cell.NewDict(16)
+	for i, m := range messages {
+		value := cell.BeginCell().MustStoreUInt(uint64(m.mode), 8).MustStoreRef(m.cell(t)).EndCell()
+		err := dict.SetIntKey(big.NewInt(int64(i)), value)
+		if err != nil {
+			t.Fatalf("failed to build messages: %v", err)
+		}
+	}
+	return cell.BeginCell().
+		MustStoreUInt(uint64(subwallet), 32).
+		MustStoreUInt(uint64(validUntil)<<32|7, 64).
+		MustStoreDict(dict).
+		EndCell()
+}
+
+func buildHighloadV3Body(t *testing.T, subwallet uint32, createdAt uint64, timeout uint64,
+	m testMessage) *cell.Cell {
+	return cell.BeginCell().
+		MustStoreUInt(uint64(subwallet), 32).
+		MustStoreRef(m.cell(t)).
+		MustStoreUInt(uint64(m.mode), 8).
+		MustStoreUInt(5, 23).
+		MustStoreUInt(createdAt, 64).
+		MustStoreUInt(timeout, 22).
+		EndCell()
+}
+
+func TestParseWalletBody(t *testing.T) {
+	loan := loanMessage()
+	transfer := testMessage{destination: testOther, value: "2", mode: 3}
+
+	tests := []struct {
+		name       string
+		version    wallet.Version
+		body       *cell.Cell
+		subwallet  uint32
+		validUntil uint32
+		seqno      uint64
+		modes      []uint8
+		err        string
+	}{
+		{
+			name:      "v3r2",
+			version:   wallet.V3R2,
+			body:      buildRegularBody(t, wallet.V3R2, 698983191, 1000, 4, loan, transfer),
+			subwallet: 698983191, validUntil: 1000, seqno: 4, modes: []uint8{1, 3},
+		},
+		{
+			name:      "v4r2",
+			version:   wallet.V4R2,
+			body:      buildRegularBody(t, wallet.V4R2, 698983191, 1000, 4, loan),
+			subwallet: 698983191, validUntil: 1000, seqno: 4, modes: []uint8{1},
+		},
+		{
+			name:    "v4r2 with plugin op",
+			version: wallet.V4R2,
+			body: cell.BeginCell().MustStoreUInt(698983191, 32).MustStoreUInt(1000, 32).MustStoreUInt(4, 32).
+				MustStoreInt(2, 8).EndCell(),
+			err: "unsupported wallet op 2",
+		},
+		{
+			name:      "v5r1",
+			version:   wallet.V5R1Final,
+			body:      buildV5Body(t, 2147483409, 1000, 4, loan, transfer),
+			subwallet: 2147483409, validUntil: 1000, seqno: 4, modes: []uint8{1, 3},
+		},
+		{
+			name:      "v5r1 without actions",
+			version:   wallet.V5R1Final,
+			body:      buildV5Body(t, 2147483409, 1000, 4),
+			subwallet: 2147483409, validUntil: 1000, seqno: 4, modes: nil,
+		},
+		{
+			name:    "v5r1 with internal op",
+			version: wallet.V5R1Final,
+			body: cell.BeginCell().MustStoreUInt(0x73696e74, 32).MustStoreUInt(0, 32).MustStoreUInt(1000, 32).
+				MustStoreUInt(4, 32).MustStoreBoolBit(false).MustStoreBoolBit(false).EndCell(),
+			err: "unexpected op 0x73696e74",
+		},
+		{
+			name:    "v5r1 with extended actions",
+			version: wallet.V5R1Final,
+			body: cell.BeginCell().MustStoreUInt(0x7369676e, 32).MustStoreUInt(0, 32).MustStoreUInt(1000, 32).
+				MustStoreUInt(4, 32).MustStoreBoolBit(false).MustStoreBoolBit(true).EndCell(),
+			err: "extended actions are not supported",
+		},
+		{
+			name:      "highload v2",
+			version:   wallet.HighloadV2R2,
+			body:      buildHighloadV2Body(t, 698983191, 1000, loan, transfer),
+			subwallet: 698983191, validUntil: 1000, seqno: 1000<<32 | 7, modes: []uint8{1, 3},
+		},
+		{
+			name:      "highload v3",
+			version:   wallet.HighloadV3,
+			body:      buildHighloadV3Body(t, 4096, 900, 100, loan),
+			subwallet: 4096, validUntil: 1000, seqno: 5, modes: []uint8{1},
+		},
+		{
+			name:    "trailing data",
+			version: wallet.V3R2,
+			body: cell.BeginCell().MustStoreUInt(698983191, 32).MustStoreUInt(1000, 32).MustStoreUInt(4, 32).
+				MustStoreUInt(1, 8).EndCell(),
+			err: "unexpected data after messages",
+		},
+		{
+			name:    "unsupported version",
+			version: wallet.V3R1,
+			body:    buildRegularBody(t, wallet.V3R2, 698983191, 1000, 4, loan),
+			err:     "unsupported wallet version",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			body, err := parseWalletBody(test.body, test.version)
+			if test.err != "" {
+				if err == nil || !strings.Contains(err.Error(), test.err) {
+					t.Fatalf("expected error %q, got %v", test.err, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if body.Subwallet != test.subwallet || body.ValidUntil != test.validUntil || body.Seqno != test.seqno {
+				t.Fatalf("expected subwallet %v, valid until %v and seqno %v, got %v, %v and %v", test.subwallet,
+					test.validUntil, test.seqno, body.Subwallet, body.ValidUntil, body.Seqno)
+			}
+			if len(body.Messages) != len(test.modes) {
+				t.Fatalf("expected %v messages, got %v", len(test.modes), len(body.Messages))
+			}
+			for i, mode := range test.modes {
+				if body.Modes[i] != mode {
+					t.Fatalf("expected mode %v of message %v, got %v", mode, i, body.Modes[i])
+				}
+			}
+			summary := body.Summary()
+			if len(summary) > 0 && (summary[0].Op != LoanRequest || summary[0].Destination != testTreasury.String()) {
+				t.Fatalf("expected a loan request to the treasury first, got %v", summary[0])
+			}
+		})
+	}
+}
+
+func newTestSigner(t *testing.T) *signer {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "wallet.key")
+	key := ed25519.NewKeyFromSeed(make([]byte, ed25519.SeedSize))
+	err := os.WriteFile(path, key, 0600)
+	if err != nil {
+		t.Fatalf("failed to write wallet secret: %v", err)
+	}
+	return newSigner(&SignerConfig{
+		Wallet: Wallet{Type: "binary", Path: path, Version: "v3r2"},
+		Policy: SignerPolicy{
+			Destinations: []string{testTreasury.String()},
+			MaxValue:     "1000",
+		},
+	})
+}
+
+func signRequest(t *testing.T, s *signer, body *cell.Cell) *SignRequest {
+	t.Helper()
+	parsed, err := parseWalletBody(body, s.version)
+	if err != nil {
+		t.Fatalf("failed to parse body: %v", err)
+	}
+	return &SignRequest{
+		PublicKey: hex.EncodeToString(s.key.Public().(ed25519.PublicKey)),
+		Version:   s.config.Wallet.Version,
+		Subwallet: s.wallet.GetSubwalletID(),
+		Hash:      hex.EncodeToString(body.Hash()),
+		Body:      body.ToBOC(),
+		Summary:   parsed.Summary(),
+	}
+}
+
+func TestSignerPolicy(t *testing.T) {
+	s := newTestSigner(t)
+	validUntil := uint32(time.Now().Add(time.Minute).Unix())
+	loan := loanMessage()
+
+	with := func(f func(m *testMessage)) testMessage {
+		m := loanMessage()
+		f(&m)
+		return m
+	}
+
+	tests := []struct {
+		name       string
+		validUntil uint32
+		messages   []testMessage
+		request    func(r *SignRequest)
+		err        string
+	}{
+		{
+			name:     "allowed",
+			messages: []testMessage{loan},
+		},
+		{
+			name:     "destination",
+			messages: []testMessage{with(func(m *testMessage) { m.destination = testOther })},
+			err:      "destination " + testOther.String() + " is not allowed",
+		},
+		{
+			name:     "max_value",
+			messages: []testMessage{with(func(m *testMessage) { m.value = "1000.000000001" })},
+			err:      "is more than max_value 1000 TON",
+		},
+		{
+			name:     "op",
+			messages: []testMessage{with(func(m *testMessage) { m.op = FinishParticipation })},
+			err:      "op finish_participation is not allowed",
+		},
+		{
+			name:     "transfer",
+			messages: []testMessage{with(func(m *testMessage) { m.op = 0 })},
+			err:      "op transfer is not allowed",
+		},
+		{
+			name:     "max_messages",
+			messages: []testMessage{loan, loan},
+			err:      "2 messages, but max_messages is 1",
+		},
+		{
+			name:     "no messages",
+			messages: []testMessage{},
+			err:      "0 messages, but max_messages is 1",
+		},
+		{
+			name:       "max_ttl",
+			validUntil: uint32(time.Now().Add(time.Hour).Unix()),
+			messages:   []testMessage{loan},
+			err:        "but max_ttl is 5m0s",
+		},
+		{
+			name:       "expired",
+			validUntil: uint32(time.Now().Add(-time.Minute).Unix()),
+			messages:   []testMessage{loan},
+			err:        "but max_ttl is 5m0s",
+		},
+		{
+			name:     "mode 128",
+			messages: []testMessage{with(func(m *testMessage) { m.mode = 128 })},
+			err:      "mode 128 or state init is not allowed",
+		},
+		{
+			name:     "state init",
+			messages: []testMessage{with(func(m *testMessage) { m.stateInit = true })},
+			err:      "mode 1 or state init is not allowed",
+		},
+		{
+			name:     "public key",
+			messages: []testMessage{loan},
+			request:  func(r *SignRequest) { r.PublicKey = hex.EncodeToString(make([]byte, 32)) },
+			err:      "unknown public key",
+		},
+		{
+			name:     "version",
+			messages: []testMessage{loan},
+			request:  func(r *SignRequest) { r.Version = "v4r2" },
+			err:      "wallet version is v3r2, not v4r2",
+		},
+		{
+			name:     "hash",
+			messages: []testMessage{loan},
+			request:  func(r *SignRequest) { r.Hash = hex.EncodeToString(make([]byte, 32)) },
+			err:      "hash doesn't match body",
+		},
+		{
+			name:     "subwallet",
+			messages: []testMessage{loan},
+			request:  func(r *SignRequest) { r.Subwallet += 1 },
+			err:      "unexpected subwallet",
+		},
+		{
+			name:     "summary",
+			messages: []testMessage{loan},
+			request:  func(r *SignRequest) { r.Summary[0].Value = "1" },
+			err:      "summary doesn't match body",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			until := validUntil
+			if test.validUntil != 0 {
+				until = test.validUntil
+			}
+			body := buildRegularBody(t, wallet.V3R2, s.bodyId, until, 1, test.messages...)
+			request := signRequest(t, s, body)
+			if test.request != nil {
+				test.request(request)
+			}
+			signature, err := s.sign(request)
+			if test.err != "" {
+				if err == nil || !strings.Contains(err.Error(), test.err) {
+					t.Fatalf("expected error %q, got %v", test.err, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !ed25519.Verify(s.key.Public().(ed25519.PublicKey), body.Hash(), signature) {
+				t.Fatal("invalid signature")
+			}
+		})
+	}
+}
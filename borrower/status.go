@@ -0,0 +1,71 @@
+package borrower
+
+import (
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"time"
+)
+
+// Status prints the locally persisted state of the borrower.
+func Status() error {
+	return runCommand(func() {
+		config := loadConfig()
+
+		store := loadStore(config)
+
+		fmt.Println("Rounds:")
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "ROUND\tSTATE\tSINCE\tDWELL\tLAST SEEN")
+		for _, o := range store.Observations {
+			if store.lastObservation(o.Round) != o {
+				continue
+			}
+			dwell := time.Unix(o.Seen, 0).Sub(time.Unix(o.Since, 0))
+			fmt.Fprintf(w, "%v\t%v\t%v\t%v\t%v\n",
+				time.Unix(int64(o.Round), 0).Format(TimeFormat), o.State, time.Unix(o.Since, 0).Format(TimeFormat),
+				dwell.Round(time.Second), time.Unix(o.Seen, 0).Format(TimeFormat))
+		}
+		w.Flush()
+
+		fmt.Println()
+		fmt.Println("Keeper messages:")
+		w = tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "ROUND\tSTATE\tMESSAGE\tSTATUS\tATTEMPTS\tLAST SENT\tTRANSACTION\tERROR")
+		for _, m := range store.KeeperMessages {
+			sentAt := "-"
+			if m.SentAt != 0 {
+				sentAt = time.Unix(m.SentAt, 0).Format(TimeFormat)
+			}
+			lt := "-"
+			if m.Lt != 0 {
+				lt = fmt.Sprint(m.Lt)
+			}
+			fmt.Fprintf(w, "%v\t%v\t%v\t%v\t%v\t%v\t%v\t%v\n",
+				time.Unix(int64(m.Round), 0).Format(TimeFormat), m.State, OpName(m.Op), m.Status, m.Attempts,
+				sentAt, lt, m.Error)
+		}
+		w.Flush()
+
+		fmt.Println()
+		fmt.Println("Sweeps:")
+		w = tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "AT\tROUNDS\tSTATUS\tAMOUNT\tBALANCE\tREQUIRED\tTRANSACTION\tERROR")
+		for _, t := range store.Sweeps {
+			rounds := ""
+			for i, o := range t.Left {
+				if i > 0 {
+					rounds += ", "
+				}
+				rounds += fmt.Sprintf("%v %v", time.Unix(int64(o.Round), 0).Format(TimeFormat), o.State)
+			}
+			lt := "-"
+			if t.Lt != 0 {
+				lt = fmt.Sprint(t.Lt)
+			}
+			fmt.Fprintf(w, "%v\t%v\t%v\t%v\t%v\t%v\t%v\t%v\n",
+				time.Unix(t.At, 0).Format(TimeFormat), rounds, t.Status, t.Amount, t.Balance, t.Required, lt, t.Error)
+		}
+		w.Flush()
+	})
+}
@@ -0,0 +1,79 @@
+package borrower
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+)
+
+var StateFile = "state.json"
+
+type Store struct {
+	path           string
+	KeeperMessages []*KeeperMessage    `json:"keeper_messages"`
+	Alerts         map[string]int64    `json:"alerts"`
+	Observations   []*StateObservation `json:"observations"`
+	Sweeps         []*SweepTransfer    `json:"sweeps"`
+	Accounts       []*RoundAccount     `json:"accounts"`
+	WalletHistory  []*WalletTransfer   `json:"wallet_history"`
+	WalletLt       uint64              `json:"wallet_lt"`
+}
+
+func dataPath(config *Config, name string) string {
+	dir := config.DataDir
+	if dir == "" {
+		dir = "."
+	}
+	return filepath.Join(dir, name)
+}
+
+func loadStore(config *Config) *Store {
+	path := dataPath(config, StateFile)
+	store := &Store{}
+	err := readJson(path, store)
+	if err != nil {
+		panic(fmt.Sprintf("Error in reading local state: %v", err))
+	}
+	store.path = path
+	return store
+}
+
+func (s *Store) save() {
+	err := writeJson(s.path, s)
+	if err != nil {
+		panic(fmt.Sprintf("Error in writing local state: %v", err))
+	}
+}
+
+// readJson leaves v untouched when the file doesn't exist yet.
+func readJson(path string, v any) error {
+	contents, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(contents, v)
+}
+
+// writeJson writes to a temporary file first and renames it, so a crash never leaves a truncated file.
+func writeJson(path string, v any) error {
+	contents, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return err
+	}
+	err = os.MkdirAll(filepath.Dir(path), 0700)
+	if err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	err = os.WriteFile(tmp, contents, 0600)
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
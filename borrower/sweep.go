@@ -0,0 +1,202 @@
+package borrower
+
+import (
+	"context"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"math/big"
+	"time"
+
+	"github.com/xssnick/tonutils-go/address"
+	"github.com/xssnick/tonutils-go/tlb"
+	"github.com/xssnick/tonutils-go/ton"
+	"github.com/xssnick/tonutils-go/ton/wallet"
+)
+
+type SweepStatus string
+
+const (
+	SweepSent    SweepStatus = "sent"
+	SweepSkipped SweepStatus = "skipped"
+	SweepFailed  SweepStatus = "failed"
+)
+
+// SweepTransfer records a sweep of surplus funds to the cold wallet, and the round states that triggered it.
+type SweepTransfer struct {
+	Left        []*StateObservation `json:"left"`
+	Status      SweepStatus         `json:"status"`
+	Destination string              `json:"destination"`
+	Balance     string              `json:"balance"`
+	Required    string              `json:"required"`
+	Amount      string              `json:"amount"`
+	At          int64               `json:"at"`
+	Hash        string              `json:"hash,omitempty"`
+	Lt          uint64              `json:"lt,omitempty"`
+	Error       string              `json:"error,omitempty"`
+}
+
+const (
+	sweepRetry = 10 * time.Minute
+	// sweepWait is how long skipped sweeps of a round are retried, since the stake and reward may reach the wallet a
+	// few blocks after the treasury transaction that changed the state of the round.
+	sweepWait    = 2 * time.Hour
+	sweepHistory = 90 * 24 * time.Hour
+)
+
+func isSweepState(state ParticipationState) bool {
+	return state == ParticipationHeld || state == ParticipationRecovering
+}
+
+// leftSweepStates returns the observations of rounds that left held or recovering state, by moving to another state
+// or by being removed from the treasury, and that no sweep has handled yet.
+func (s *Store) leftSweepStates(rounds map[uint32]bool) []*StateObservation {
+	left := []*StateObservation{}
+	for i, o := range s.Observations {
+		if !isSweepState(o.State) || s.swept(o) {
+			continue
+		}
+		later := false
+		for _, n := range s.Observations[i+1:] {
+			if n.Round == o.Round {
+				later = true
+				break
+			}
+		}
+		if later || !rounds[o.Round] {
+			left = append(left, o)
+		}
+	}
+	return left
+}
+
+// swept is true when a sweep sent the surplus after the observation, or when sweeps were skipped for it for longer
+// than sweepWait, so there's nothing left to wait for.
+func (s *Store) swept(o *StateObservation) bool {
+	for _, t := range s.Sweeps {
+		if t.Status == SweepFailed {
+			continue
+		}
+		for _, l := range t.Left {
+			if l.Round == o.Round && l.State == o.State && l.Since == o.Since &&
+				(t.Status == SweepSent || time.Since(time.Unix(t.At, 0)) > sweepWait) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func (s *Store) lastSweep() *SweepTransfer {
+	if len(s.Sweeps) == 0 {
+		return nil
+	}
+	return s.Sweeps[len(s.Sweeps)-1]
+}
+
+// pruneSweeps forgets sweeps that were made a long time ago.
+func (s *Store) pruneSweeps() {
+	cutoff := time.Now().Add(-sweepHistory).Unix()
+	sweeps := []*SweepTransfer{}
+	for _, t := range s.Sweeps {
+		if t.At > cutoff {
+			sweeps = append(sweeps, t)
+		}
+	}
+	if len(sweeps) != len(s.Sweeps) {
+		s.Sweeps = sweeps
+		s.save()
+	}
+}
+
+func loadSweepConfig(config Sweep) (*address.Address, *big.Int, *big.Int) {
+	destination := parseAnyAddress(config.Address)
+	buffer := big.NewInt(0)
+	if config.Buffer != "" {
+		b, err := tlb.FromTON(config.Buffer)
+		if err != nil {
+			panic(fmt.Sprintf("Error, invalid buffer of sweep: %v", err))
+		}
+		buffer = b.Nano()
+	}
+	minAmount := big.NewInt(0)
+	if config.MinAmount != "" {
+		m, err := tlb.FromTON(config.MinAmount)
+		if err != nil {
+			panic(fmt.Sprintf("Error, invalid min_amount of sweep: %v", err))
+		}
+		minAmount = m.Nano()
+	}
+	return destination, buffer, minAmount
+}
+
+// sweepSurplus sends the funds above what the next loan request needs, plus the buffer, to the cold wallet after
+// rounds leave held or recovering state, when their reward and stake are back in the wallet. A failed sweep is
+// retried after sweepRetry, and raises an alert. A skipped sweep is retried after sweepRetry too, until sweepWait
+// passes, since the funds may not have arrived yet. It returns when to process again for a retry, or zero.
+func sweepSurplus(config *Config, api ton.APIClientWrapped, ctx context.Context, store *Store, alerter *alerter,
+	mainchainInfo *ton.BlockIDExt, treasuryAddress *address.Address, rounds map[uint32]bool) (wait time.Duration) {
+	if config.Sweep.Address == "" {
+		return 0
+	}
+	left := store.leftSweepStates(rounds)
+	if len(left) == 0 {
+		return 0
+	}
+	last := store.lastSweep()
+	if last != nil && last.Status != SweepSent && time.Since(time.Unix(last.At, 0)) < sweepRetry {
+		return sweepRetry - time.Since(time.Unix(last.At, 0))
+	}
+
+	t := &SweepTransfer{
+		Left:        left,
+		Destination: config.Sweep.Address,
+		At:          time.Now().Unix(),
+	}
+	defer func() {
+		if err := recover(); err != nil {
+			t.Status = SweepFailed
+			t.Error = fmt.Sprint(err)
+			alerter.raise("sweep", AlertWarning, "Sweep to %v failed: %v", config.Sweep.Address, err)
+		}
+		store.Sweeps = append(store.Sweeps, t)
+		store.save()
+		if t.Status != SweepSent {
+			wait = sweepRetry
+		}
+	}()
+
+	destination, buffer, minAmount := loadSweepConfig(config.Sweep)
+
+	w := loadWallet(config.Wallet, api)
+	verifyWalletAddress(config.Wallet, w)
+	if destination.StringRaw() == w.Address().StringRaw() {
+		panic("Error, sweep address is the address of the wallet")
+	}
+
+	balance := loadBalance(w, mainchainInfo)
+	required := loadWalletReserve(config, api, ctx, mainchainInfo, treasuryAddress)
+	required.Add(required, buffer)
+	required.Add(required, withdrawFeeMargin)
+	amount := new(big.Int).Sub(balance, required)
+	t.Balance = tlb.FromNanoTON(balance).String()
+	t.Required = tlb.FromNanoTON(required).String()
+
+	if amount.Sign() <= 0 || amount.Cmp(minAmount) < 0 {
+		t.Status = SweepSkipped
+		t.Amount = "0"
+		log.Printf("🧹 No surplus to sweep, balance is %v TON and %v TON is required", t.Balance, t.Required)
+		return
+	}
+	t.Amount = tlb.FromNanoTON(amount).String()
+
+	log.Printf("🧹 Sweeping %v TON to %v", t.Amount, destination.String())
+	message := wallet.SimpleMessage(destination, tlb.FromNanoTON(amount), nil)
+	message.InternalMessage.Bounce = destination.IsBounceable()
+	tx := sendWalletMessage(w, message)
+	t.Status = SweepSent
+	t.Hash = hex.EncodeToString(tx.Hash)
+	t.Lt = tx.LT
+	log.Printf("🧹 Swept %v TON to %v", t.Amount, destination.String())
+	return 0
+}
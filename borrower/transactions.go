@@ -0,0 +1,47 @@
+package borrower
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/xssnick/tonutils-go/address"
+	"github.com/xssnick/tonutils-go/tlb"
+	"github.com/xssnick/tonutils-go/ton"
+)
+
+// transactionsPage is the number of transactions that are listed in one query to the liteserver.
+const transactionsPage = 20
+
+// listTransactions returns the transactions of an account after lt since, oldest first. It stops early when stop
+// returns true for the oldest transaction of a page, or when the liteserver has no older transactions.
+func listTransactions(api ton.APIClientWrapped, ctx context.Context, account *tlb.Account, a *address.Address,
+	since uint64, stop func(oldest *tlb.Transaction) bool) []*tlb.Transaction {
+	transactions := []*tlb.Transaction{}
+	lt, hash := account.LastTxLT, account.LastTxHash
+	for lt > since {
+		page, err := api.ListTransactions(ctx, a, transactionsPage, lt, hash)
+		if errors.Is(err, ton.ErrNoTransactionsWereFound) {
+			break
+		}
+		if err != nil {
+			panic(fmt.Sprintf("Error in listing transactions of %v: %v", a.String(), err))
+		}
+		if len(page) == 0 {
+			break
+		}
+		transactions = append(page, transactions...)
+		oldest := page[0]
+		if stop != nil && stop(oldest) {
+			break
+		}
+		lt, hash = oldest.PrevTxLT, oldest.PrevTxHash
+	}
+	filtered := []*tlb.Transaction{}
+	for _, tx := range transactions {
+		if tx.LT > since {
+			filtered = append(filtered, tx)
+		}
+	}
+	return filtered
+}
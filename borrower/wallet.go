@@ -0,0 +1,238 @@
+package borrower
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/xssnick/tonutils-go/address"
+	"github.com/xssnick/tonutils-go/tlb"
+	"github.com/xssnick/tonutils-go/ton"
+	"github.com/xssnick/tonutils-go/ton/wallet"
+)
+
+// highloadTimeDrift is subtracted from the creation time of highload v3 messages, since the wallet rejects
+// messages created in its future.
+const highloadTimeDrift = 30
+
+func parseWalletVersion(config Wallet) wallet.Version {
+	switch config.Version {
+	case "v3r2":
+		return wallet.V3R2
+	case "v4r2":
+		return wallet.V4R2
+	case "v5r1":
+		return wallet.V5R1Final
+	case "highload_v2r2":
+		return wallet.HighloadV2R2
+	case "highload_v3":
+		return wallet.HighloadV3
+	}
+	panic(fmt.Sprintf("Error, invalid wallet version, expected v3r2, v4r2, v5r1, highload_v2r2, or highload_v3 "+
+		"but got: %v", config.Version))
+}
+
+// loadVersionConfig returns the version of the wallet with the parameters that v5 and highload v3 wallets need.
+func loadVersionConfig(config Wallet) wallet.VersionConfig {
+	version := parseWalletVersion(config)
+	switch version {
+	case wallet.V5R1Final:
+		networkGlobalId := config.NetworkGlobalId
+		if networkGlobalId == 0 {
+			networkGlobalId = wallet.MainnetGlobalID
+		}
+		return wallet.ConfigV5R1Final{NetworkGlobalID: networkGlobalId, Workchain: 0}
+	case wallet.HighloadV3:
+		if config.MessageTtl < time.Minute || config.MessageTtl >= (1<<22)*time.Second {
+			panic(fmt.Sprintf("Error, message_ttl of highload_v3 wallet should be between 1m and 1165h, but got: %v",
+				config.MessageTtl))
+		}
+		return wallet.ConfigHighloadV3{
+			MessageTTL:     uint32(config.MessageTtl.Seconds()),
+			MessageBuilder: buildHighloadMessage,
+		}
+	}
+	return version
+}
+
+// buildHighloadMessage derives the query id of a highload v3 message from its creation time, which keeps query
+// ids unique for 97 days as long as at most one message is sent per second. A message that reuses a query id is
+// rejected by the wallet, so it's never sent twice.
+func buildHighloadMessage(_ context.Context, _ uint32) (uint32, int64, error) {
+	createdAt := time.Now().Unix() - highloadTimeDrift
+	return uint32(createdAt % (1 << 23)), createdAt, nil
+}
+
+func loadWallet(config Wallet, api ton.APIClientWrapped) *wallet.Wallet {
+	versionConfig := loadVersionConfig(config)
+
+	var w *wallet.Wallet
+	if config.Type == "remote" {
+		w = loadRemoteWallet(config, api, versionConfig)
+	} else {
+		secret := loadWalletSecret(config)
+		w = walletFromSecret(api, config.Type, secret, versionConfig)
+	}
+
+	return withSubwallet(w, config)
+}
+
+func walletFromSecret(api ton.APIClientWrapped, secretType string, secret []byte,
+	version wallet.VersionConfig) *wallet.Wallet {
+	var w *wallet.Wallet
+	var err error
+	if secretType == "mnemonic" {
+		seed := strings.Split(strings.Trim(string(secret), " \n\t"), " ")
+		w, err = wallet.FromSeed(api, seed, version)
+	} else if secretType == "binary" {
+		w, err = wallet.FromPrivateKey(api, secret, version)
+	} else {
+		panic(fmt.Sprintf("Error, invalid wallet type, expected mnemonic, binary, or remote but got: %v", secretType))
+	}
+	if err != nil {
+		panic(fmt.Sprintf("Error in loading wallet: %v", err))
+	}
+
+	return w
+}
+
+// withSubwallet switches to the configured subwallet, when it's not the default one of the wallet version.
+func withSubwallet(w *wallet.Wallet, config Wallet) *wallet.Wallet {
+	if config.SubwalletId == nil || *config.SubwalletId == w.GetSubwalletID() {
+		return w
+	}
+	if parseWalletVersion(config) == wallet.V5R1Final && *config.SubwalletId > 0x7fff {
+		panic(fmt.Sprintf("Error, subwallet_id of v5r1 wallet should be at most 32767, but got: %v",
+			*config.SubwalletId))
+	}
+	sub, err := w.GetSubwallet(*config.SubwalletId)
+	if err != nil {
+		panic(fmt.Sprintf("Error in loading subwallet %v: %v", *config.SubwalletId, err))
+	}
+	return sub
+}
+
+// walletBodyId returns the id that the wallet puts in the body of its external messages, which is the subwallet id,
+// or the wallet id of v5 wallets derived from the network and the subwallet.
+func walletBodyId(w *wallet.Wallet, version wallet.VersionConfig) uint32 {
+	if v, ok := version.(wallet.ConfigV5R1Final); ok {
+		return wallet.V5R1ID{
+			NetworkGlobalID: v.NetworkGlobalID,
+			WorkChain:       v.Workchain,
+			SubwalletNumber: uint16(w.GetSubwalletID()),
+		}.Serialized()
+	}
+	return w.GetSubwalletID()
+}
+
+// checkSentTransaction makes sure the transaction of the wallet actually sent the message. Wallets without seqno,
+// like highload wallets, accept the external message and commit before their actions, so a successful compute phase
+// doesn't mean the message was sent.
+func checkSentTransaction(tx *tlb.Transaction, destination *address.Address) error {
+	if !isTransactionSuccessful(tx) {
+		return fmt.Errorf("wallet transaction failed")
+	}
+	description := tx.Description.(tlb.TransactionDescriptionOrdinary)
+	if description.ActionPhase == nil || !description.ActionPhase.Success {
+		return fmt.Errorf("action phase of wallet transaction failed")
+	}
+	if tx.IO.Out == nil {
+		return fmt.Errorf("wallet transaction has no outgoing messages")
+	}
+	messages, err := tx.IO.Out.ToSlice()
+	if err != nil {
+		return fmt.Errorf("invalid outgoing messages of wallet transaction: %w", err)
+	}
+	for _, m := range messages {
+		if m.MsgType == tlb.MsgTypeInternal && m.AsInternal().DstAddr.StringRaw() == destination.StringRaw() {
+			return nil
+		}
+	}
+	return fmt.Errorf("wallet transaction didn't send a message to %v", destination.String())
+}
+
+// VerifyWallet loads the wallet and checks its address when borrowing is active and the address is configured, so
+// that a wrong wallet is reported when the borrower starts. It's only reported, since keeper duties and monitoring
+// don't need the wallet, and RequestLoan checks the wallet again before every request.
+func VerifyWallet() error {
+	return runCommand(func() {
+		config := loadConfig()
+		if !config.Borrow.Active || config.Wallet.Address == "" {
+			return
+		}
+
+		w := loadWallet(config.Wallet, nil)
+
+		verifyWalletAddress(config.Wallet, w)
+
+		log.Printf("👛 Using wallet %v", w.Address().String())
+	})
+}
+
+// verifyWalletAddress makes sure the address derived from the secret, version, and subwallet of the wallet is the
+// configured one, so that a wrong secret file doesn't silently send requests from another wallet.
+func verifyWalletAddress(config Wallet, w *wallet.Wallet) {
+	if config.Address == "" {
+		return
+	}
+	expected := parseAnyAddress(config.Address)
+	if expected.Workchain() != w.Address().Workchain() || !bytes.Equal(expected.Data(), w.Address().Data()) {
+		panic(fmt.Sprintf("Error, wallet address %v derived from the secret doesn't match the configured address %v, "+
+			"check path, version, and subwallet_id of the wallet", w.Address().String(), config.Address))
+	}
+}
+
+// verifyWalletNetwork makes sure the treasury, the wallet, and the network that the liteservers are on agree on
+// mainnet or testnet. A wallet address in raw form has no flag, so only the treasury is compared with the network.
+func verifyWalletNetwork(config Wallet, treasuryAddress *address.Address, api ton.APIClientWrapped,
+	ctx context.Context, mainchainInfo *ton.BlockIDExt) {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	blockchainConfig, err := api.GetBlockchainConfig(ctx, mainchainInfo, ConfigGlobalId)
+	if err != nil {
+		panic(fmt.Sprintf("Error in getting blockchain config: %v", err))
+	}
+	globalId := GetGlobalId(blockchainConfig.Get(ConfigGlobalId))
+	testnet := globalId != wallet.MainnetGlobalID
+
+	if walletAddress, err := address.ParseAddr(config.Address); err == nil &&
+		walletAddress.IsTestnetOnly() != treasuryAddress.IsTestnetOnly() {
+		panic(fmt.Sprintf("Error, wallet address %v is for %v, but treasury address %v is for %v", config.Address,
+			networkName(walletAddress.IsTestnetOnly()), treasuryAddress.String(),
+			networkName(treasuryAddress.IsTestnetOnly())))
+	}
+	if treasuryAddress.IsTestnetOnly() != testnet {
+		panic(fmt.Sprintf("Error, treasury address %v is for %v, but the network is %v", treasuryAddress.String(),
+			networkName(treasuryAddress.IsTestnetOnly()), networkName(testnet)))
+	}
+	if parseWalletVersion(config) == wallet.V5R1Final {
+		networkGlobalId := loadVersionConfig(config).(wallet.ConfigV5R1Final).NetworkGlobalID
+		if networkGlobalId != globalId {
+			panic(fmt.Sprintf("Error, network_global_id of wallet is %v, but the network has global id %v",
+				networkGlobalId, globalId))
+		}
+	}
+}
+
+func networkName(testnet bool) string {
+	if testnet {
+		return "testnet"
+	}
+	return "mainnet"
+}
+
+// parseAnyAddress parses an address in user-friendly or raw form.
+func parseAnyAddress(a string) *address.Address {
+	parsed, err := address.ParseAddr(a)
+	if err != nil {
+		parsed, err = address.ParseRawAddr(a)
+	}
+	if err != nil {
+		panic(fmt.Sprintf("Error, invalid address %v: %v", a, err))
+	}
+	return parsed
+}
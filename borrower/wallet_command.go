@@ -0,0 +1,232 @@
+package borrower
+
+import (
+	"context"
+	"fmt"
+	"math/big"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/xssnick/tonutils-go/address"
+	"github.com/xssnick/tonutils-go/tlb"
+	"github.com/xssnick/tonutils-go/ton"
+	"github.com/xssnick/tonutils-go/ton/wallet"
+)
+
+// withdrawFeeMargin is kept in the wallet on withdrawals, in addition to the reserve, to pay for fees.
+var withdrawFeeMargin = tlb.MustFromTON("0.1").Nano()
+
+// WalletInit generates a new wallet of the configured type and version, and writes its secret file.
+func WalletInit() error {
+	return runCommand(func() {
+		config := loadConfig()
+		if config.Wallet.Type == "remote" {
+			panic("Remote wallet has no secret file, initialize the wallet of the signer instead")
+		}
+		if _, err := os.Stat(config.Wallet.Path); err == nil {
+			panic(fmt.Sprintf("Wallet secret %v already exists", config.Wallet.Path))
+		}
+
+		seed := wallet.NewSeed()
+		var secret []byte
+		switch config.Wallet.Type {
+		case "mnemonic":
+			secret = []byte(strings.Join(seed, " ") + "\n")
+		case "binary":
+			key, err := wallet.SeedToPrivateKey(seed, "", false)
+			if err != nil {
+				panic(fmt.Sprintf("Error in deriving wallet key: %v", err))
+			}
+			secret = key
+		}
+		w := walletFromSecret(nil, config.Wallet.Type, secret, loadVersionConfig(config.Wallet))
+		w = withSubwallet(w, config.Wallet)
+
+		contents := secret
+		if config.Wallet.Encrypted {
+			contents = encryptWalletSecret(secret)
+		}
+		writeSecretFile(config.Wallet.Path, contents)
+
+		fmt.Printf("Created wallet %v in %v\n", w.Address().String(), config.Wallet.Path)
+		fmt.Println()
+		fmt.Println("Write down these words, they are the only way to recover the wallet:")
+		fmt.Println(strings.Join(seed, " "))
+	})
+}
+
+// WalletAddress prints the address of the wallet in every form.
+func WalletAddress() error {
+	return runCommand(func() {
+		config := loadConfig()
+
+		w := loadWallet(config.Wallet, nil)
+
+		a := w.Address()
+		tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintf(tw, "Bounceable:\t%v\n", a.Bounce(true).Testnet(false).String())
+		fmt.Fprintf(tw, "Non-bounceable:\t%v\n", a.Bounce(false).Testnet(false).String())
+		fmt.Fprintf(tw, "Testnet bounceable:\t%v\n", a.Bounce(true).Testnet(true).String())
+		fmt.Fprintf(tw, "Testnet non-bounceable:\t%v\n", a.Bounce(false).Testnet(true).String())
+		fmt.Fprintf(tw, "Raw:\t%v\n", a.StringRaw())
+		fmt.Fprintf(tw, "Version:\t%v\n", config.Wallet.Version)
+		fmt.Fprintf(tw, "Subwallet:\t%v\n", w.GetSubwalletID())
+		tw.Flush()
+	})
+}
+
+// WalletDeploy deploys the wallet contract, which needs some TON sent to its non-bounceable address first.
+func WalletDeploy() error {
+	return runCommand(func() {
+		config := loadConfig()
+
+		api, ctx := loadApi(config)
+
+		w := loadWallet(config.Wallet, api)
+		verifyWalletAddress(config.Wallet, w)
+
+		mainchainInfo := loadMainchainInfo(api, ctx)
+
+		account := loadWalletAccount(api, ctx, mainchainInfo, w)
+		if account.IsActive && account.State.Status == tlb.AccountStatusActive {
+			fmt.Printf("Wallet %v is already deployed\n", w.Address().String())
+			return
+		}
+		if !account.IsActive || account.State.Balance.Nano().Sign() == 0 {
+			panic(fmt.Sprintf("Wallet %v has no balance, send some TON to %v first", w.Address().String(),
+				w.WalletAddress().String()))
+		}
+
+		fmt.Printf("Deploying wallet %v\n", w.Address().String())
+		message := wallet.SimpleMessage(w.WalletAddress(), tlb.MustFromTON("0"), nil)
+		message.InternalMessage.Bounce = false
+		sendWalletMessage(w, message)
+
+		fmt.Printf("Deployed wallet %v\n", w.Address().String())
+	})
+}
+
+// WalletBalance prints the balance of the wallet, and how much of it is reserved for the next loan request.
+func WalletBalance() error {
+	return runCommand(func() {
+		config := loadConfig()
+
+		api, ctx := loadApi(config)
+
+		w := loadWallet(config.Wallet, api)
+
+		treasuryAddress := address.MustParseAddr(config.Treasury)
+
+		mainchainInfo := loadMainchainInfo(api, ctx)
+
+		account := loadWalletAccount(api, ctx, mainchainInfo, w)
+		status := "nonexist"
+		balance := big.NewInt(0)
+		if account.IsActive {
+			status = string(account.State.Status)
+			balance = account.State.Balance.Nano()
+		}
+		reserve := loadWalletReserve(config, api, ctx, mainchainInfo, treasuryAddress)
+		available := new(big.Int).Sub(balance, reserve)
+		if available.Sign() < 0 {
+			available.SetInt64(0)
+		}
+
+		tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintf(tw, "Wallet:\t%v\n", w.Address().String())
+		fmt.Fprintf(tw, "Status:\t%v\n", status)
+		fmt.Fprintf(tw, "Balance:\t%v TON\n", tlb.FromNanoTON(balance).String())
+		fmt.Fprintf(tw, "Reserved:\t%v TON\n", tlb.FromNanoTON(reserve).String())
+		fmt.Fprintf(tw, "Available:\t%v TON\n", tlb.FromNanoTON(available).String())
+		tw.Flush()
+	})
+}
+
+// WalletWithdraw sends TON from the wallet, keeping the reserve of the next loan request in it.
+func WalletWithdraw(to string, amount string, comment string) error {
+	return runCommand(func() {
+		config := loadConfig()
+
+		api, ctx := loadApi(config)
+
+		w := loadWallet(config.Wallet, api)
+		verifyWalletAddress(config.Wallet, w)
+
+		treasuryAddress := address.MustParseAddr(config.Treasury)
+
+		destination := parseAnyAddress(to)
+		value, err := tlb.FromTON(amount)
+		if err != nil || value.Nano().Sign() <= 0 {
+			panic(fmt.Sprintf("Invalid amount: %v", amount))
+		}
+
+		mainchainInfo := loadMainchainInfo(api, ctx)
+
+		verifyWalletNetwork(config.Wallet, treasuryAddress, api, ctx, mainchainInfo)
+
+		balance := loadBalance(w, mainchainInfo)
+		reserve := loadWalletReserve(config, api, ctx, mainchainInfo, treasuryAddress)
+		remaining := new(big.Int).Sub(balance, value.Nano())
+		remaining.Sub(remaining, withdrawFeeMargin)
+		if remaining.Cmp(reserve) < 0 {
+			available := new(big.Int).Sub(balance, reserve)
+			available.Sub(available, withdrawFeeMargin)
+			if available.Sign() < 0 {
+				available.SetInt64(0)
+			}
+			panic(fmt.Sprintf("Withdrawing %v TON would leave less than the %v TON reserved for the next loan "+
+				"request, at most %v TON can be withdrawn", value.String(), tlb.FromNanoTON(reserve).String(),
+				tlb.FromNanoTON(available).String()))
+		}
+
+		message, err := w.BuildTransfer(destination, value, destination.IsBounceable(), comment)
+		if err != nil {
+			panic(fmt.Sprintf("Error in building transfer: %v", err))
+		}
+
+		fmt.Printf("Sending %v TON to %v\n", value.String(), destination.String())
+		sendWalletMessage(w, message)
+
+		fmt.Printf("Sent %v TON to %v\n", value.String(), destination.String())
+	})
+}
+
+// loadWalletReserve returns the value of the next loan request, or zero when borrowing is inactive.
+func loadWalletReserve(config *Config, api ton.APIClientWrapped, ctx context.Context, mainchainInfo *ton.BlockIDExt,
+	treasuryAddress *address.Address) *big.Int {
+	if !config.Borrow.Active {
+		return big.NewInt(0)
+	}
+	return loadRequestValue(config.Borrow, api, ctx, mainchainInfo, treasuryAddress)
+}
+
+func loadWalletAccount(api ton.APIClientWrapped, ctx context.Context, mainchainInfo *ton.BlockIDExt,
+	w *wallet.Wallet) *tlb.Account {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	account, err := api.GetAccount(ctx, mainchainInfo, w.Address())
+	if err != nil {
+		panic(fmt.Sprintf("Error in getting wallet account: %v", err))
+	}
+	return account
+}
+
+// sendWalletMessage sends a message from the wallet, and waits until the wallet transaction sends it.
+func sendWalletMessage(w *wallet.Wallet, message *wallet.Message) *tlb.Transaction {
+	ctx, cancel := context.WithTimeout(context.Background(), 120*time.Second)
+	defer cancel()
+
+	tx, _, err := w.SendWaitTransaction(ctx, message)
+	if err != nil {
+		panic(fmt.Sprintf("Error in sending message: %v", err))
+	}
+
+	err = checkSentTransaction(tx, message.InternalMessage.DstAddr)
+	if err != nil {
+		panic(fmt.Sprintf("Error in sending message: %v", err))
+	}
+	return tx
+}
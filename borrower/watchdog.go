@@ -0,0 +1,126 @@
+package borrower
+
+import (
+	"fmt"
+	"time"
+)
+
+// StateObservation records when a round was first and last seen in a state.
+type StateObservation struct {
+	Round uint32             `json:"round"`
+	State ParticipationState `json:"state"`
+	Since int64              `json:"since"`
+	Seen  int64              `json:"seen"`
+}
+
+const observationHistory = 30 * 24 * time.Hour
+
+var defaultStateTimeouts = map[ParticipationState]time.Duration{
+	ParticipationOpen:         10 * time.Minute,
+	ParticipationDistributing: 30 * time.Minute,
+	ParticipationStaked:       10 * time.Minute,
+	ParticipationValidating:   10 * time.Minute,
+	ParticipationHeld:         10 * time.Minute,
+}
+
+// observe records that a round is in a state now, and returns the observation of its current state.
+func (s *Store) observe(roundSince uint32, state ParticipationState) *StateObservation {
+	now := time.Now().Unix()
+	o := s.lastObservation(roundSince)
+	if o == nil || o.State != state {
+		o = &StateObservation{
+			Round: roundSince,
+			State: state,
+			Since: now,
+		}
+		s.Observations = append(s.Observations, o)
+	}
+	o.Seen = now
+	s.save()
+	return o
+}
+
+func (s *Store) lastObservation(roundSince uint32) *StateObservation {
+	for i := len(s.Observations) - 1; i >= 0; i-- {
+		if s.Observations[i].Round == roundSince {
+			return s.Observations[i]
+		}
+	}
+	return nil
+}
+
+// pruneObservations forgets old observations of rounds that are no longer tracked by the treasury.
+func (s *Store) pruneObservations(rounds map[uint32]bool) {
+	cutoff := time.Now().Add(-observationHistory).Unix()
+	observations := []*StateObservation{}
+	for _, o := range s.Observations {
+		if rounds[o.Round] || o.Seen > cutoff {
+			observations = append(observations, o)
+		}
+	}
+	if len(observations) != len(s.Observations) {
+		s.Observations = observations
+		s.save()
+	}
+}
+
+// expectedStateEnd returns when a round is expected to leave its current state, based on network config. Rounds
+// in distributing state are expected to leave it shortly after entering it, and recovering and burning rounds are
+// checked by checkRecovery.
+func expectedStateEnd(o *StateObservation, participation *Participation, roundParticipateTime uint32,
+	validatorsElectedFor uint32) (time.Time, bool) {
+	var end int64
+	switch o.State {
+	case ParticipationOpen:
+		end = int64(roundParticipateTime)
+	case ParticipationDistributing:
+		end = o.Since
+	case ParticipationStaked:
+		end = int64(o.Round)
+	case ParticipationValidating:
+		end = int64(o.Round + validatorsElectedFor)
+	case ParticipationHeld:
+		end = int64(participation.StakeHeldUntil)
+	default:
+		return time.Time{}, false
+	}
+	if end < o.Since {
+		end = o.Since
+	}
+	return time.Unix(end, 0), true
+}
+
+// checkDwell raises a warning when a round stays in a state longer than its timeout after it's expected to leave
+// it, and a critical alert after twice the timeout.
+func checkDwell(alerter *alerter, config Alerts, o *StateObservation, expectedEnd time.Time) {
+	timeout := stateTimeout(config, o.State)
+	overdue := time.Since(expectedEnd)
+	if overdue < timeout {
+		return
+	}
+
+	formattedRoundSince := time.Unix(int64(o.Round), 0).Format(TimeFormat)
+	dwell := time.Since(time.Unix(o.Since, 0)).Round(time.Second)
+	level := AlertWarning
+	if overdue >= 2*timeout {
+		level = AlertCritical
+	}
+	alerter.raise(fmt.Sprintf("stuck:%d:%v:%v", o.Round, o.State, level), level,
+		"Round %v is stuck in %v state for %v, %v after it was expected to leave it",
+		formattedRoundSince, o.State, dwell, overdue.Round(time.Second))
+}
+
+func stateTimeout(config Alerts, state ParticipationState) time.Duration {
+	if timeout, found := config.StateTimeouts[state.String()]; found && timeout > 0 {
+		return timeout
+	}
+	return defaultStateTimeouts[state]
+}
+
+func exposeObservation(o *StateObservation) {
+	labels := map[string]string{"round": fmt.Sprint(o.Round)}
+	setGauge("borrower_round_state", "The participation state of a round tracked by the treasury.",
+		labels, float64(o.State))
+	setElapsedGauge("borrower_round_state_dwell_seconds", "The time a round has been in its current state.",
+		labels, o.Since)
+}
@@ -0,0 +1,155 @@
+package main
+
+import (
+	"borrower/borrower"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+const usage = `Usage: borrower [command]
+
+Without a command, the borrower runs as a service.
+
+Commands:
+  status              Print the local state of the borrower
+  keys                Print the validator keys of the engine, use --cleanup to remove expired keys
+  inspect engine      Print the config of the validator engine, use --json for the full config
+  doctor              Check whether the borrower is ready to request a loan
+  forecast            Forecast the wallet balance over the next rounds, use --rounds to set how many
+  recommend           Estimate the reward of the next round and recommend a min payment for the borrow config
+  index               Index the loan requests of every validator in past rounds of the treasury into a local history
+  history             Print the indexed loan requests, use --round and --validator to filter, --format for csv or json
+  report pnl          Print the profit and loss of each round, use --from and --to to set dates, --format for csv or json
+  report market       Print the lowest accepted RoI and reward share, demand, and supply of indexed rounds, with trends
+  report wallet       Print the wallet history linked to rounds and the unreconciled rounds, with the same flags
+  wallet init         Generate a new wallet and write its secret file
+  wallet address      Print the address of the wallet in every form
+  wallet deploy       Deploy the wallet contract after it's funded
+  wallet balance      Print the balance of the wallet and how much is reserved for the next loan request
+  wallet withdraw     Send TON from the wallet to an address, keeping the reserve, as in: wallet withdraw <to> <amount>
+  wallet encrypt      Encrypt the secret file of the wallet with a passphrase, use --out to set the output path
+  signer              Run the reference signer of a remote wallet, use --config to set its config file
+`
+
+func command(args []string) {
+	var err error
+	switch args[0] {
+	case "status":
+		err = borrower.Status()
+	case "keys":
+		flags := flag.NewFlagSet("keys", flag.ExitOnError)
+		cleanup := flags.Bool("cleanup", false, "remove expired keys of rounds not tracked by the treasury")
+		flags.Parse(args[1:])
+		err = borrower.Keys(*cleanup)
+	case "inspect":
+		flags := flag.NewFlagSet("inspect", flag.ExitOnError)
+		asJson := flags.Bool("json", false, "print as JSON")
+		what := subcommand(args, flags, "engine")
+		if what == "engine" {
+			err = borrower.InspectEngine(*asJson)
+		}
+	case "doctor":
+		err = borrower.Doctor()
+	case "forecast":
+		flags := flag.NewFlagSet("forecast", flag.ExitOnError)
+		rounds := flags.Int("rounds", 0, "number of rounds to forecast, defaults to rounds of forecast config")
+		flags.Parse(args[1:])
+		err = borrower.PrintForecast(*rounds)
+	case "recommend":
+		err = borrower.Recommend()
+	case "index":
+		flags := flag.NewFlagSet("index", flag.ExitOnError)
+		depth := flags.Duration("depth", 0, "how far back the first index goes, defaults to 90 days")
+		flags.Parse(args[1:])
+		err = borrower.Index(*depth)
+	case "history":
+		flags := flag.NewFlagSet("history", flag.ExitOnError)
+		round := flags.String("round", "", "unix time of the start of the round")
+		validator := flags.String("validator", "", "address of the validator")
+		format := flags.String("format", "table", "output format, table, csv, or json")
+		flags.Parse(args[1:])
+		err = borrower.PrintHistory(*round, *validator, *format)
+	case "report":
+		flags := flag.NewFlagSet("report", flag.ExitOnError)
+		from := flags.String("from", "", "first date of rounds to report, as YYYY-MM-DD")
+		to := flags.String("to", "", "last date of rounds to report, as YYYY-MM-DD")
+		format := flags.String("format", "table", "output format, table, csv, or json")
+		what := subcommand(args, flags, "pnl", "wallet", "market")
+		if *format != "table" && *format != "csv" && *format != "json" {
+			fmt.Fprintf(os.Stderr, "Unknown format: %v\n", *format)
+			os.Exit(2)
+		}
+		switch what {
+		case "pnl":
+			err = borrower.ReportPnl(*from, *to, *format)
+		case "wallet":
+			err = borrower.ReportWallet(*from, *to, *format)
+		case "market":
+			err = borrower.ReportMarket(*from, *to, *format)
+		}
+	case "wallet":
+		flags := flag.NewFlagSet("wallet", flag.ExitOnError)
+		out := flags.String("out", "", "path of the encrypted secret file, defaults to the wallet path with .enc")
+		comment := flags.String("comment", "", "comment of the withdrawal")
+		what := subcommand(args, flags, "init", "address", "deploy", "balance", "withdraw", "encrypt")
+		switch what {
+		case "init":
+			err = borrower.WalletInit()
+		case "address":
+			err = borrower.WalletAddress()
+		case "deploy":
+			err = borrower.WalletDeploy()
+		case "balance":
+			err = borrower.WalletBalance()
+		case "withdraw":
+			if flags.NArg() != 2 {
+				fmt.Fprintf(os.Stderr, "Usage: borrower wallet withdraw [--comment text] <to> <amount>\n")
+				os.Exit(2)
+			}
+			err = borrower.WalletWithdraw(flags.Arg(0), flags.Arg(1), *comment)
+		case "encrypt":
+			err = borrower.WalletEncrypt(*out)
+		}
+	case "signer":
+		flags := flag.NewFlagSet("signer", flag.ExitOnError)
+		config := flags.String("config", borrower.SignerConfigFile, "path of the signer config file")
+		flags.Parse(args[1:])
+		stop := make(chan struct{})
+		go func() {
+			stopSignal := make(chan os.Signal, 1)
+			signal.Notify(stopSignal, syscall.SIGINT, syscall.SIGTERM)
+			<-stopSignal
+			close(stop)
+		}()
+		err = borrower.ServeSigner(*config, stop)
+	case "help", "-h", "--help":
+		fmt.Print(usage)
+	default:
+		fmt.Fprintf(os.Stderr, "Unknown command: %v\n\n%v", args[0], usage)
+		os.Exit(2)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "❌ %s\n", err)
+		os.Exit(1)
+	}
+}
+
+// subcommand parses the flags after a subcommand, and exits when the subcommand is not one of the expected ones.
+func subcommand(args []string, flags *flag.FlagSet, expected ...string) string {
+	if len(args) < 2 {
+		fmt.Fprintf(os.Stderr, "Missing subcommand of %v\n\n%v", args[0], usage)
+		os.Exit(2)
+	}
+	flags.Parse(args[2:])
+	for _, e := range expected {
+		if args[1] == e {
+			return e
+		}
+	}
+	fmt.Fprintf(os.Stderr, "Unknown subcommand of %v: %v\n\n%v", args[0], args[1], usage)
+	os.Exit(2)
+	return ""
+}
@@ -13,8 +13,18 @@ import (
 )
 
 func main() {
+	if len(os.Args) > 1 {
+		command(os.Args[1:])
+		return
+	}
+
 	log.Println("🟢 Borrower started")
 
+	err := borrower.VerifyWallet()
+	if err != nil {
+		log.Printf("❌ %s, loan requests fail until the wallet is fixed", err)
+	}
+
 	stop, done := start()
 
 	go func() {
@@ -35,10 +45,24 @@ func start() (context.CancelFunc, <-chan struct{}) {
 
 	var wg sync.WaitGroup
 
+	events := make(chan borrower.Event, 2)
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		borrower.Monitor(ctx.Done(), events)
+	}()
+
 	wg.Add(1)
 	go func() {
 		defer wg.Done()
-		loop(ctx.Done())
+		borrower.ServeMetrics(ctx.Done())
+	}()
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		loop(ctx.Done(), events)
 	}()
 
 	go func() {
@@ -49,7 +73,7 @@ func start() (context.CancelFunc, <-chan struct{}) {
 	return cancel, done
 }
 
-func loop(stop <-chan struct{}) {
+func loop(stop <-chan struct{}, events <-chan borrower.Event) {
 	processTimer := time.NewTimer(0)
 	requestTimer := time.NewTimer(0)
 	for {
@@ -60,6 +84,15 @@ func loop(stop <-chan struct{}) {
 			requestTimer.Stop()
 			return
 
+		case event := <-events:
+			// Events of the monitor run the job right away, and the timers remain as a safety net
+			if event == borrower.EventProcess {
+				processTimer.Reset(0)
+			} else if event == borrower.EventRequest {
+				requestTimer.Reset(0)
+			}
+			continue
+
 		case <-processTimer.C:
 			processWait := borrower.Process()
 			if processWait <= 0 {